@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// definitions is the shape of a RabbitMQ definitions file. The management
+// API lists exchanges, queues and bindings with the same fields.
+type definitions struct {
+	Vhosts    []vhostDef    `json:"vhosts,omitempty"`
+	Exchanges []exchangeDef `json:"exchanges"`
+	Queues    []queueDef    `json:"queues"`
+	Bindings  []bindingDef  `json:"bindings"`
+}
+
+type vhostDef struct {
+	Name string `json:"name"`
+}
+
+type exchangeDef struct {
+	Name       string         `json:"name"`
+	Vhost      string         `json:"vhost"`
+	Type       string         `json:"type"`
+	Durable    bool           `json:"durable"`
+	AutoDelete bool           `json:"auto_delete"`
+	Internal   bool           `json:"internal"`
+	Arguments  map[string]any `json:"arguments"`
+}
+
+type queueDef struct {
+	Name       string         `json:"name"`
+	Vhost      string         `json:"vhost"`
+	Durable    bool           `json:"durable"`
+	AutoDelete bool           `json:"auto_delete"`
+	Exclusive  bool           `json:"exclusive,omitempty"`
+	Arguments  map[string]any `json:"arguments"`
+}
+
+type bindingDef struct {
+	Source          string         `json:"source"`
+	Vhost           string         `json:"vhost"`
+	Destination     string         `json:"destination"`
+	DestinationType string         `json:"destination_type"`
+	RoutingKey      string         `json:"routing_key"`
+	Arguments       map[string]any `json:"arguments"`
+}
+
+func definitionsFor(t routing.Topology, vhost string) definitions {
+	defs := definitions{Vhosts: []vhostDef{{Name: vhost}}}
+	for _, ex := range t.Exchanges {
+		defs.Exchanges = append(defs.Exchanges, exchangeDef{
+			Name:       ex.Name,
+			Vhost:      vhost,
+			Type:       ex.Kind,
+			Durable:    ex.Durable,
+			AutoDelete: ex.AutoDelete,
+			Internal:   ex.Internal,
+			Arguments:  arguments(ex.Args),
+		})
+	}
+	for _, q := range t.Queues {
+		defs.Queues = append(defs.Queues, queueDef{
+			Name:       q.Name,
+			Vhost:      vhost,
+			Durable:    q.Durable,
+			AutoDelete: q.AutoDelete,
+			Exclusive:  q.Exclusive,
+			Arguments:  arguments(q.Args),
+		})
+	}
+	for _, b := range t.Bindings {
+		defs.Bindings = append(defs.Bindings, bindingDef{
+			Source:          b.Exchange,
+			Vhost:           vhost,
+			Destination:     b.Queue,
+			DestinationType: "queue",
+			RoutingKey:      string(b.Key),
+			Arguments:       arguments(b.Args),
+		})
+	}
+	return defs
+}
+
+func arguments(args map[string]any) map[string]any {
+	if args == nil {
+		return map[string]any{}
+	}
+	return args
+}
+
+// sameArguments compares argument tables by their JSON form, so 10 and 10.0
+// are equal the way they are once they have been through the broker.
+func sameArguments(a, b map[string]any) bool {
+	ja, errA := json.Marshal(arguments(a))
+	jb, errB := json.Marshal(arguments(b))
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
+
+// diff lists how have falls short of want. Things have holds that want
+// doesn't mention, such as per-player queues, are not differences.
+func diff(want, have definitions) []string {
+	var out []string
+
+	exchanges := map[string]exchangeDef{}
+	for _, ex := range have.Exchanges {
+		exchanges[ex.Name] = ex
+	}
+	for _, w := range want.Exchanges {
+		h, ok := exchanges[w.Name]
+		switch {
+		case !ok:
+			out = append(out, fmt.Sprintf("missing exchange %s (%s)", w.Name, w.Type))
+		case h.Type != w.Type:
+			out = append(out, fmt.Sprintf("exchange %s is %s, want %s", w.Name, h.Type, w.Type))
+		case h.Durable != w.Durable || h.AutoDelete != w.AutoDelete || h.Internal != w.Internal:
+			out = append(out, fmt.Sprintf("exchange %s has durable=%v auto_delete=%v internal=%v, want %v %v %v",
+				w.Name, h.Durable, h.AutoDelete, h.Internal, w.Durable, w.AutoDelete, w.Internal))
+		case !sameArguments(h.Arguments, w.Arguments):
+			out = append(out, fmt.Sprintf("exchange %s has arguments %v, want %v", w.Name, h.Arguments, w.Arguments))
+		}
+	}
+
+	queues := map[string]queueDef{}
+	for _, q := range have.Queues {
+		queues[q.Name] = q
+	}
+	for _, w := range want.Queues {
+		h, ok := queues[w.Name]
+		switch {
+		case !ok:
+			out = append(out, fmt.Sprintf("missing queue %s", w.Name))
+		case h.Durable != w.Durable || h.AutoDelete != w.AutoDelete || h.Exclusive != w.Exclusive:
+			out = append(out, fmt.Sprintf("queue %s has durable=%v auto_delete=%v exclusive=%v, want %v %v %v",
+				w.Name, h.Durable, h.AutoDelete, h.Exclusive, w.Durable, w.AutoDelete, w.Exclusive))
+		case !sameArguments(h.Arguments, w.Arguments):
+			out = append(out, fmt.Sprintf("queue %s has arguments %v, want %v", w.Name, h.Arguments, w.Arguments))
+		}
+	}
+
+	bindings := map[[3]string]bool{}
+	for _, b := range have.Bindings {
+		if b.DestinationType == "queue" {
+			bindings[[3]string{b.Source, b.Destination, b.RoutingKey}] = true
+		}
+	}
+	for _, w := range want.Bindings {
+		if !bindings[[3]string{w.Source, w.Destination, w.RoutingKey}] {
+			out = append(out, fmt.Sprintf("missing binding %s -> %s with key %q", w.Source, w.Destination, w.RoutingKey))
+		}
+	}
+
+	sort.Strings(out)
+	return out
+}
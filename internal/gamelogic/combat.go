@@ -0,0 +1,188 @@
+package gamelogic
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CombatMode picks how wars are decided.
+type CombatMode string
+
+const (
+	// CombatPower compares the two sides' summed strength. The weaker side
+	// loses every unit in the location; on a tie both do.
+	CombatPower CombatMode = "power"
+	// CombatDice fights rounds of dice rolls, killing units one hit at a
+	// time, until a side is wiped out or the rounds run out.
+	CombatDice CombatMode = "dice"
+)
+
+// Side is one party to a war.
+type Side int
+
+const (
+	SideNone Side = iota
+	SideAttacker
+	SideDefender
+)
+
+// Round is one exchange of dice rolls in a battle.
+type Round struct {
+	AttackerRolls  []int
+	DefenderRolls  []int
+	AttackerLosses int
+	DefenderLosses int
+}
+
+// Battle is how a war went. Winner is SideNone for a draw. The power levels
+// are only set by CombatPower, and Rounds only by CombatDice.
+type Battle struct {
+	Winner         Side
+	AttackerLosses []Unit
+	DefenderLosses []Unit
+	AttackerPower  int
+	DefenderPower  int
+	Rounds         []Round
+}
+
+// CombatEngine decides a war between units meeting in one location.
+type CombatEngine interface {
+	Fight(attackers, defenders []Unit, catalog *Catalog) Battle
+}
+
+// PowerCombat is the CombatPower engine.
+type PowerCombat struct{}
+
+func (PowerCombat) Fight(attackers, defenders []Unit, catalog *Catalog) Battle {
+	b := Battle{
+		AttackerPower: catalog.attackPower(attackers),
+		DefenderPower: catalog.defensePower(defenders),
+	}
+	switch {
+	case b.AttackerPower > b.DefenderPower:
+		b.Winner = SideAttacker
+		b.DefenderLosses = defenders
+	case b.DefenderPower > b.AttackerPower:
+		b.Winner = SideDefender
+		b.AttackerLosses = attackers
+	default:
+		b.AttackerLosses = attackers
+		b.DefenderLosses = defenders
+	}
+	return b
+}
+
+// DefaultDiceSides makes a unit with strength 10 hit on every roll.
+const DefaultDiceSides = 10
+
+// DefaultMaxRounds ends a battle nobody has won in a draw.
+const DefaultMaxRounds = 10
+
+// DiceCombat is the CombatDice engine. Each round every surviving unit rolls
+// a die with Sides sides and hits if it rolls its strength or under: attack
+// for attackers, defense for defenders. Hits land at the same time, and each
+// kills one enemy unit, cheapest first. It is safe for concurrent use; seed
+// its source to make battles reproducible.
+type DiceCombat struct {
+	Sides     int
+	MaxRounds int
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewDiceCombat rolls dice drawn from rng.
+func NewDiceCombat(rng *rand.Rand) *DiceCombat {
+	return &DiceCombat{
+		Sides:     DefaultDiceSides,
+		MaxRounds: DefaultMaxRounds,
+		rng:       rng,
+	}
+}
+
+// NewSeededDiceCombat rolls dice from a source seeded with seed, so the same
+// seed rolls the same dice.
+func NewSeededDiceCombat(seed int64) *DiceCombat {
+	return NewDiceCombat(rand.New(rand.NewSource(seed)))
+}
+
+// NewRandomDiceCombat rolls dice from a source seeded with the current time.
+func NewRandomDiceCombat() *DiceCombat {
+	return NewSeededDiceCombat(time.Now().UnixNano())
+}
+
+func (d *DiceCombat) Fight(attackers, defenders []Unit, catalog *Catalog) Battle {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	attacking := casualtyOrder(attackers, catalog)
+	defending := casualtyOrder(defenders, catalog)
+	var b Battle
+	for round := 0; round < d.MaxRounds && len(attacking) > 0 && len(defending) > 0; round++ {
+		var r Round
+		attackerHits, defenderHits := 0, 0
+		for _, u := range attacking {
+			roll := d.rng.Intn(d.Sides) + 1
+			r.AttackerRolls = append(r.AttackerRolls, roll)
+			if roll <= catalog.unitAttack(u) {
+				attackerHits++
+			}
+		}
+		for _, u := range defending {
+			roll := d.rng.Intn(d.Sides) + 1
+			r.DefenderRolls = append(r.DefenderRolls, roll)
+			if roll <= catalog.unitDefense(u) {
+				defenderHits++
+			}
+		}
+		r.DefenderLosses = min(attackerHits, len(defending))
+		r.AttackerLosses = min(defenderHits, len(attacking))
+		b.DefenderLosses = append(b.DefenderLosses, defending[:r.DefenderLosses]...)
+		b.AttackerLosses = append(b.AttackerLosses, attacking[:r.AttackerLosses]...)
+		defending = defending[r.DefenderLosses:]
+		attacking = attacking[r.AttackerLosses:]
+		b.Rounds = append(b.Rounds, r)
+	}
+	switch {
+	case len(attacking) > 0 && len(defending) == 0:
+		b.Winner = SideAttacker
+	case len(defending) > 0 && len(attacking) == 0:
+		b.Winner = SideDefender
+	}
+	return b
+}
+
+// casualtyOrder sorts units in the order they fall: cheapest first, then
+// weakest.
+func casualtyOrder(units []Unit, catalog *Catalog) []Unit {
+	sorted := append([]Unit(nil), units...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, _ := catalog.Stats(sorted[i].Rank)
+		b, _ := catalog.Stats(sorted[j].Rank)
+		if a.Cost != b.Cost {
+			return a.Cost < b.Cost
+		}
+		if a.Attack+a.Defense != b.Attack+b.Defense {
+			return a.Attack+a.Defense < b.Attack+b.Defense
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+// print tells the player how b went.
+func (b Battle) print(attacker, defender string) {
+	if len(b.Rounds) == 0 {
+		fmt.Printf("Attacker has a power level of %v\n", b.AttackerPower)
+		fmt.Printf("Defender has a power level of %v\n", b.DefenderPower)
+		return
+	}
+	for i, r := range b.Rounds {
+		fmt.Printf("Round %d: %s rolled %v, %s rolled %v; %s lost %d, %s lost %d\n",
+			i+1, attacker, r.AttackerRolls, defender, r.DefenderRolls,
+			attacker, r.AttackerLosses, defender, r.DefenderLosses)
+	}
+}
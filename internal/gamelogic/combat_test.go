@@ -0,0 +1,28 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiceCombatSeeded(t *testing.T) {
+	attackers := []Unit{{ID: 2, Rank: RankCavalry}, {ID: 1, Rank: RankInfantry}}
+	defenders := []Unit{{ID: 1, Rank: RankArtillery}, {ID: 2, Rank: RankInfantry}}
+
+	b := NewSeededDiceCombat(42).Fight(attackers, defenders, DefaultCatalog())
+
+	// Infantry falls first on both sides. The artillery hits on anything,
+	// and the cavalry on 5 or under.
+	want := Battle{
+		Winner:         SideDefender,
+		AttackerLosses: []Unit{{ID: 1, Rank: RankInfantry}, {ID: 2, Rank: RankCavalry}},
+		DefenderLosses: []Unit{{ID: 2, Rank: RankInfantry}},
+		Rounds: []Round{
+			{AttackerRolls: []int{6, 8}, DefenderRolls: []int{9, 1}, AttackerLosses: 1},
+			{AttackerRolls: []int{4}, DefenderRolls: []int{6, 8}, AttackerLosses: 1, DefenderLosses: 1},
+		},
+	}
+	if !reflect.DeepEqual(b, want) {
+		t.Errorf("got %+v\nwant %+v", b, want)
+	}
+}
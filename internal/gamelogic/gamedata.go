@@ -19,10 +19,13 @@ type Unit struct {
 	Location Location
 }
 
+// ArmyMove is a player moving Units together from FromLocation to
+// ToLocation. The units carry their new location.
 type ArmyMove struct {
-	Player     Player
-	Units      []Unit
-	ToLocation Location
+	Player       Player
+	Units        []Unit
+	FromLocation Location
+	ToLocation   Location
 }
 
 type RecognitionOfWar struct {
@@ -30,23 +33,18 @@ type RecognitionOfWar struct {
 	Defender Player
 }
 
-type Location string
-
-func getAllRanks() map[UnitRank]struct{} {
-	return map[UnitRank]struct{}{
-		RankInfantry:  {},
-		RankCavalry:   {},
-		RankArtillery: {},
-	}
+// WarResult is how a war was decided. The attacker's client resolves the war
+// and sends the result to the defender, and each applies their own losses.
+// WarID identifies the war, so a result delivered twice is only applied
+// once. Winner is empty on a draw.
+type WarResult struct {
+	WarID          string
+	Attacker       string
+	Defender       string
+	Location       Location
+	Winner         string
+	AttackerLosses []Unit
+	DefenderLosses []Unit
 }
 
-func getAllLocations() map[Location]struct{} {
-	return map[Location]struct{}{
-		"americas":   {},
-		"europe":     {},
-		"africa":     {},
-		"asia":       {},
-		"australia":  {},
-		"antarctica": {},
-	}
-}
+type Location string
@@ -7,6 +7,8 @@ import (
 	"math/rand"
 	"os"
 	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
 func PrintClientHelp() {
@@ -14,6 +16,8 @@ func PrintClientHelp() {
 	fmt.Println("* move <location> <unitID> <unitID> <unitID>...")
 	fmt.Println("    example:")
 	fmt.Println("    move asia 1")
+	fmt.Println("    units in a move start together and travel along borders,")
+	fmt.Println("    as far as their rank allows (see status)")
 	fmt.Println("* spawn <location> <rank>")
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
@@ -33,6 +37,9 @@ func ClientWelcome() (string, error) {
 		return "", errors.New("you must enter a username. goodbye")
 	}
 	username := words[0]
+	if err := routing.ValidateUsername(username); err != nil {
+		return "", fmt.Errorf("invalid username: %w", err)
+	}
 	fmt.Printf("Welcome, %s!\n", username)
 	PrintClientHelp()
 	return username, nil
@@ -89,4 +96,24 @@ func (gs *GameState) CommandStatus() {
 	for _, unit := range p.Units {
 		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
 	}
+
+	world := gs.Map()
+	fmt.Printf("You are playing on the %s map with %s combat and these ranks:\n", world.Name(), world.Combat())
+	for _, r := range world.Units().Ranks() {
+		fmt.Printf("* %s: attack %d, defense %d, cost %d, movement %d", r.Name, r.Attack, r.Defense, r.Cost, r.Movement)
+		if len(r.Abilities) > 0 {
+			fmt.Printf(", %v", r.Abilities)
+		}
+		fmt.Println()
+	}
+	units := make([]Unit, 0, len(p.Units))
+	for _, unit := range p.Units {
+		units = append(units, unit)
+	}
+	if regions, bonus := world.Bonus(units); len(regions) > 0 {
+		fmt.Printf("You hold %d region(s), worth a bonus of %d:\n", len(regions), bonus)
+		for _, r := range regions {
+			fmt.Printf("* %s (+%d defense for your units in it)\n", r.Name, r.Bonus)
+		}
+	}
 }
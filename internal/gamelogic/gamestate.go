@@ -7,7 +7,16 @@ import (
 type GameState struct {
 	Player Player
 	Paused bool
+	World  *WorldMap
+	Dice   *DiceCombat
 	mu     *sync.RWMutex
+	// settled holds the IDs of wars whose results have been applied. It only
+	// lives as long as the process: a result redelivered after a restart is
+	// applied again, to whatever units then have the IDs it names.
+	settled map[string]struct{}
+	// nextUnitID is the ID the next spawned unit gets. IDs are never reused,
+	// so a unit lost in a war can't be confused with a later one.
+	nextUnitID int
 }
 
 func NewGameState(username string) *GameState {
@@ -16,8 +25,12 @@ func NewGameState(username string) *GameState {
 			Username: username,
 			Units:    map[int]Unit{},
 		},
-		Paused: false,
-		mu:     &sync.RWMutex{},
+		Paused:     false,
+		World:      DefaultMap(),
+		Dice:       NewRandomDiceCombat(),
+		mu:         &sync.RWMutex{},
+		settled:    map[string]struct{}{},
+		nextUnitID: 1,
 	}
 }
 
@@ -39,19 +52,83 @@ func (gs *GameState) isPaused() bool {
 	return gs.Paused
 }
 
+// Map returns the map the game is played on.
+func (gs *GameState) Map() *WorldMap {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.World
+}
+
+// SetMap changes the map the game is played on.
+func (gs *GameState) SetMap(m *WorldMap) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.World = m
+}
+
+// SetDice makes wars under CombatDice roll with d, for instance one with a
+// fixed seed to replay a game.
+func (gs *GameState) SetDice(d *DiceCombat) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Dice = d
+}
+
+// combat returns the engine wars are decided by on the current map.
+func (gs *GameState) combat() CombatEngine {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	if gs.World.Combat() == CombatDice {
+		return gs.Dice
+	}
+	return PowerCombat{}
+}
+
+// WarSettled reports whether the result of the war with id has been
+// applied. Settled wars are only remembered in memory, so after a restart it
+// reports false for every war and a redelivered one is fought again.
+func (gs *GameState) WarSettled(id string) bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	_, ok := gs.settled[id]
+	return ok
+}
+
+// settleWar records the war with id as applied, returning false if it
+// already was. Wars without an ID are never recorded.
+func (gs *GameState) settleWar(id string) bool {
+	if id == "" {
+		return true
+	}
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if _, ok := gs.settled[id]; ok {
+		return false
+	}
+	gs.settled[id] = struct{}{}
+	return true
+}
+
+// newUnitID hands out the next unit ID.
+func (gs *GameState) newUnitID() int {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	id := gs.nextUnitID
+	gs.nextUnitID++
+	return id
+}
+
 func (gs *GameState) addUnit(u Unit) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 	gs.Player.Units[u.ID] = u
 }
 
-func (gs *GameState) removeUnitsInLocation(loc Location) {
+func (gs *GameState) removeUnits(units []Unit) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	for k, v := range gs.Player.Units {
-		if v.Location == loc {
-			delete(gs.Player.Units, k)
-		}
+	for _, u := range units {
+		delete(gs.Player.Units, u.ID)
 	}
 }
 
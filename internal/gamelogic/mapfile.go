@@ -0,0 +1,76 @@
+package gamelogic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MapDefinition is a map as written in a map file and as the server sends
+// it to clients. Units are the ranks played with on it; without any, the
+// DefaultRanks are. Combat is how wars are decided, CombatPower by default.
+type MapDefinition struct {
+	Name        string      `json:"name" yaml:"name"`
+	Territories []Territory `json:"territories" yaml:"territories"`
+	Regions     []Region    `json:"regions,omitempty" yaml:"regions,omitempty"`
+	Start       []Location  `json:"starting_positions,omitempty" yaml:"starting_positions,omitempty"`
+	Units       []RankStats `json:"units,omitempty" yaml:"units,omitempty"`
+	Combat      CombatMode  `json:"combat,omitempty" yaml:"combat,omitempty"`
+}
+
+// Territory is a location and the locations it borders. Borders go both
+// ways, so each only needs listing on one side.
+type Territory struct {
+	Name    Location   `json:"name" yaml:"name"`
+	Borders []Location `json:"borders,omitempty" yaml:"borders,omitempty"`
+}
+
+// Region is a group of territories. A player holding all of them adds its
+// bonus to the defense of every unit they defend one of them with.
+type Region struct {
+	Name        string     `json:"name" yaml:"name"`
+	Territories []Location `json:"territories" yaml:"territories"`
+	Bonus       int        `json:"bonus" yaml:"bonus"`
+}
+
+// ReadMapFile loads a map from a .json, .yaml or .yml file.
+func ReadMapFile(path string) (*WorldMap, error) {
+	var def MapDefinition
+	if err := decodeFile(path, &def); err != nil {
+		return nil, fmt.Errorf("map file %s: %w", path, err)
+	}
+	if def.Name == "" {
+		def.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	m, err := NewWorldMap(def)
+	if err != nil {
+		return nil, fmt.Errorf("map file %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// decodeFile reads a JSON or YAML file, going by its extension, into v.
+// Fields v doesn't have are an error, to catch typos.
+func decodeFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return dec.Decode(v)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		return dec.Decode(v)
+	default:
+		return fmt.Errorf("unknown format %q, want .json, .yaml or .yml", ext)
+	}
+}
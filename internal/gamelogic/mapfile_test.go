@@ -0,0 +1,123 @@
+package gamelogic
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFile writes contents to name in a temporary directory and returns its
+// path.
+func writeFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadMapFile(t *testing.T) {
+	files := map[string]string{
+		"islands.json": `{
+			"territories": [
+				{"name": "north", "borders": ["middle"]},
+				{"name": "middle", "borders": ["south"]},
+				{"name": "south"}
+			],
+			"regions": [{"name": "ends", "territories": ["north", "south"], "bonus": 2}],
+			"starting_positions": ["north"],
+			"combat": "dice"
+		}`,
+		"islands.yaml": `
+territories:
+  - name: north
+    borders: [middle]
+  - name: middle
+    borders: [south]
+  - name: south
+regions:
+  - name: ends
+    territories: [north, south]
+    bonus: 2
+starting_positions: [north]
+combat: dice
+`,
+	}
+	for name, contents := range files {
+		m, err := ReadMapFile(writeFile(t, name, contents))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		// Without a name in the file, the map is named after it.
+		if m.Name() != "islands" {
+			t.Errorf("%s: named %q, want islands", name, m.Name())
+		}
+		if got, want := m.Neighbours("middle"), []Location{"north", "south"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: middle borders %v, want %v", name, got, want)
+		}
+		if m.Combat() != CombatDice {
+			t.Errorf("%s: combat %s, want dice", name, m.Combat())
+		}
+		want := []Region{{Name: "ends", Territories: []Location{"north", "south"}, Bonus: 2}}
+		if got := m.Definition().Regions; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: regions %+v, want %+v", name, got, want)
+		}
+		if err := m.CheckSpawn("south", nil); err == nil {
+			t.Errorf("%s: spawned outside the starting positions", name)
+		}
+	}
+}
+
+func TestReadMapFileShipped(t *testing.T) {
+	for _, path := range []string{"../../maps/classic.yaml", "../../maps/archipelago.json"} {
+		if _, err := ReadMapFile(path); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestReadMapFileRejects(t *testing.T) {
+	tests := []struct {
+		name, file, contents, want string
+	}{
+		{
+			name:     "unknown JSON field",
+			file:     "typo.json",
+			contents: `{"territories": [{"name": "a", "boarders": ["b"]}, {"name": "b"}]}`,
+			want:     "boarders",
+		},
+		{
+			name:     "unknown YAML field",
+			file:     "typo.yaml",
+			contents: "territories:\n  - name: a\n    boarders: [b]\n  - name: b\n",
+			want:     "boarders",
+		},
+		{
+			name:     "border to a missing territory",
+			file:     "missing.json",
+			contents: `{"territories": [{"name": "a", "borders": ["atlantis"]}]}`,
+			want:     "atlantis",
+		},
+		{
+			name:     "region with a missing territory",
+			file:     "region.yaml",
+			contents: "territories:\n  - name: a\nregions:\n  - name: r\n    territories: [a, atlantis]\n",
+			want:     "atlantis",
+		},
+		{
+			name:     "unknown format",
+			file:     "map.toml",
+			contents: `name = "a"`,
+			want:     "unknown format",
+		},
+	}
+	for _, tt := range tests {
+		_, err := ReadMapFile(writeFile(t, tt.file, tt.contents))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error about %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package gamelogic
+
+import "github.com/bootdotdev/learn-pub-sub-starter/internal/metrics"
+
+var (
+	unitsSpawned = metrics.NewCounter(
+		"peril_units_spawned_total",
+		"Units spawned by this player, by rank.",
+		"rank",
+	)
+	movesMade = metrics.NewCounter(
+		"peril_moves_made_total",
+		"Moves made by this player.",
+	)
+	movesSeen = metrics.NewCounter(
+		"peril_moves_handled_total",
+		"Moves received from the army_moves exchange, by outcome.",
+		"outcome",
+	)
+	warsFought = metrics.NewCounter(
+		"peril_wars_fought_total",
+		"Wars this player has applied the result of, by outcome.",
+		"outcome",
+	)
+)
@@ -12,10 +12,29 @@ const (
 	MoveOutcomeSamePlayer MoveOutcome = iota
 	MoveOutComeSafe
 	MoveOutcomeMakeWar
+	MoveOutcomeIllegal
 )
 
-func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
+func (o MoveOutcome) String() string {
+	switch o {
+	case MoveOutcomeSamePlayer:
+		return "same_player"
+	case MoveOutComeSafe:
+		return "safe"
+	case MoveOutcomeMakeWar:
+		return "make_war"
+	case MoveOutcomeIllegal:
+		return "illegal"
+	default:
+		return fmt.Sprintf("MoveOutcome(%d)", int(o))
+	}
+}
+
+func (gs *GameState) HandleMove(move ArmyMove) (outcome MoveOutcome) {
 	defer fmt.Println("------------------------")
+	defer func() {
+		movesSeen.With(outcome.String()).Inc()
+	}()
 	player := gs.GetPlayerSnap()
 
 	fmt.Println()
@@ -29,6 +48,11 @@ func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 		return MoveOutcomeSamePlayer
 	}
 
+	if err := gs.CheckMove(move); err != nil {
+		fmt.Printf("%s made an illegal move! %v\n", move.Player.Username, err)
+		return MoveOutcomeIllegal
+	}
+
 	overlappingLocation := getOverlappingLocation(player, move.Player)
 	if overlappingLocation != "" {
 		fmt.Printf("You have units in %s! You are at war with %s!\n", overlappingLocation, move.Player.Username)
@@ -49,7 +73,19 @@ func getOverlappingLocation(p1 Player, p2 Player) Location {
 	return ""
 }
 
+// CommandMove moves units as PrepareMove and ApplyMove would together.
 func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
+	mv, err := gs.PrepareMove(words)
+	if err != nil {
+		return ArmyMove{}, err
+	}
+	gs.ApplyMove(mv)
+	return mv, nil
+}
+
+// PrepareMove works out the move a command asks for without changing any
+// state, so the move can be recorded before it takes effect.
+func (gs *GameState) PrepareMove(words []string) (ArmyMove, error) {
 	if gs.isPaused() {
 		return ArmyMove{}, errors.New("the game is paused, you can not move units")
 	}
@@ -57,8 +93,7 @@ func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
 		return ArmyMove{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
 	}
 	newLocation := Location(words[1])
-	locations := getAllLocations()
-	if _, ok := locations[newLocation]; !ok {
+	if !gs.Map().Has(newLocation) {
 		return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", newLocation)
 	}
 	unitIDs := []int{}
@@ -71,22 +106,58 @@ func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
 		unitIDs = append(unitIDs, unitID)
 	}
 
-	newUnits := []Unit{}
+	player := gs.GetPlayerSnap()
+	var from Location
+	units := []Unit{}
 	for _, unitID := range unitIDs {
-		unit, ok := gs.GetUnit(unitID)
+		unit, ok := player.Units[unitID]
 		if !ok {
 			return ArmyMove{}, fmt.Errorf("error: unit with ID %v not found", unitID)
 		}
+		if from == "" {
+			from = unit.Location
+		} else if unit.Location != from {
+			return ArmyMove{}, fmt.Errorf("error: units in one move must start together, but %v is in %s and %v is in %s", units[0].ID, from, unit.ID, unit.Location)
+		}
+		units = append(units, unit)
+	}
+	if err := gs.Map().CheckMove(from, newLocation, units); err != nil {
+		return ArmyMove{}, err
+	}
+
+	newUnits := []Unit{}
+	for _, unit := range units {
 		unit.Location = newLocation
-		gs.UpdateUnit(unit)
+		player.Units[unit.ID] = unit
 		newUnits = append(newUnits, unit)
 	}
+	return ArmyMove{
+		FromLocation: from,
+		ToLocation:   newLocation,
+		Units:        newUnits,
+		Player:       player,
+	}, nil
+}
+
+// CheckMove returns why a move another player sent breaks the rules, or nil
+// if it doesn't.
+func (gs *GameState) CheckMove(mv ArmyMove) error {
+	if len(mv.Units) == 0 {
+		return errors.New("error: no units moved")
+	}
+	for _, unit := range mv.Units {
+		if unit.Location != mv.ToLocation {
+			return fmt.Errorf("error: unit %v is in %s, not %s", unit.ID, unit.Location, mv.ToLocation)
+		}
+	}
+	return gs.Map().CheckMove(mv.FromLocation, mv.ToLocation, mv.Units)
+}
 
-	mv := ArmyMove{
-		ToLocation: newLocation,
-		Units:      newUnits,
-		Player:     gs.GetPlayerSnap(),
+// ApplyMove moves this player's units as mv says.
+func (gs *GameState) ApplyMove(mv ArmyMove) {
+	for _, unit := range mv.Units {
+		gs.UpdateUnit(unit)
 	}
 	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
-	return mv, nil
+	movesMade.With().Inc()
 }
@@ -11,24 +11,30 @@ func (gs *GameState) CommandSpawn(words []string) error {
 	}
 
 	locationName := words[1]
-	locations := getAllLocations()
-	if _, ok := locations[Location(locationName)]; !ok {
-		return fmt.Errorf("error: %s is not a valid location", locationName)
+	if err := gs.Map().CheckSpawn(Location(locationName), gs.getUnitsSnap()); err != nil {
+		return err
 	}
 
 	rank := words[2]
-	units := getAllRanks()
-	if _, ok := units[UnitRank(rank)]; !ok {
-		return fmt.Errorf("error: %s is not a valid unit", rank)
+	catalog := gs.Map().Units()
+	stats, ok := catalog.Stats(UnitRank(rank))
+	if !ok {
+		names := []UnitRank{}
+		for _, r := range catalog.Ranks() {
+			names = append(names, r.Name)
+		}
+		return fmt.Errorf("error: %s is not a valid unit, choose from %v", rank, names)
 	}
 
-	id := len(gs.getUnitsSnap()) + 1
+	id := gs.newUnitID()
 	gs.addUnit(Unit{
 		ID:       id,
 		Rank:     UnitRank(rank),
 		Location: Location(locationName),
 	})
 
-	fmt.Printf("Spawned a(n) %s in %s with id %v\n", rank, locationName, id)
+	fmt.Printf("Spawned a(n) %s in %s with id %v (attack %d, defense %d, cost %d)\n",
+		rank, locationName, id, stats.Attack, stats.Defense, stats.Cost)
+	unitsSpawned.With(rank).Inc()
 	return nil
 }
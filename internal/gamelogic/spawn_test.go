@@ -0,0 +1,24 @@
+package gamelogic
+
+import "testing"
+
+func TestSpawnNeverReusesIDs(t *testing.T) {
+	gs := NewGameState("alice")
+	for _, loc := range []string{"europe", "asia"} {
+		if err := gs.CommandSpawn([]string{"spawn", loc, "infantry"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lost, _ := gs.GetUnit(1)
+	gs.removeUnits([]Unit{lost})
+	if err := gs.CommandSpawn([]string{"spawn", "asia", "cavalry"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if u, ok := gs.GetUnit(2); !ok || u.Rank != RankInfantry {
+		t.Errorf("unit 2 is %+v, want the infantry in asia left alone", u)
+	}
+	if u, ok := gs.GetUnit(3); !ok || u.Rank != RankCavalry {
+		t.Errorf("unit 3 is %+v, want the new cavalry", u)
+	}
+}
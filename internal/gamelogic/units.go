@@ -0,0 +1,167 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Ability is a special rule a rank plays by.
+type Ability string
+
+const (
+	// AbilityFlying units ignore borders: every territory is one step away.
+	AbilityFlying Ability = "flying"
+	// AbilityFortify units defend at double strength.
+	AbilityFortify Ability = "fortify"
+)
+
+var knownAbilities = map[Ability]struct{}{
+	AbilityFlying:  {},
+	AbilityFortify: {},
+}
+
+// RankStats describes a rank of unit. Attack and Defense are its strength in
+// wars it starts and wars started on it, Cost what it takes to spawn one, and
+// Movement how many borders it crosses in one move.
+type RankStats struct {
+	Name      UnitRank  `json:"name" yaml:"name"`
+	Attack    int       `json:"attack" yaml:"attack"`
+	Defense   int       `json:"defense" yaml:"defense"`
+	Cost      int       `json:"cost" yaml:"cost"`
+	Movement  int       `json:"movement" yaml:"movement"`
+	Abilities []Ability `json:"abilities,omitempty" yaml:"abilities,omitempty"`
+}
+
+// Has reports whether units of this rank have ability a.
+func (s RankStats) Has(a Ability) bool {
+	for _, ability := range s.Abilities {
+		if ability == a {
+			return true
+		}
+	}
+	return false
+}
+
+// CatalogDefinition is a unit catalog as written in a catalog file.
+type CatalogDefinition struct {
+	Ranks []RankStats `json:"ranks" yaml:"ranks"`
+}
+
+// Catalog is the set of ranks units can be spawned as.
+type Catalog struct {
+	ranks []RankStats
+	index map[UnitRank]int
+	// defenseBonus is added to every unit's defense.
+	defenseBonus int
+}
+
+// NewCatalog checks ranks and builds a catalog of them, in the order given.
+func NewCatalog(ranks []RankStats) (*Catalog, error) {
+	if len(ranks) == 0 {
+		return nil, errors.New("catalog has no ranks")
+	}
+	c := &Catalog{index: map[UnitRank]int{}}
+	for _, r := range ranks {
+		if r.Name == "" {
+			return nil, errors.New("catalog has a rank without a name")
+		}
+		if _, ok := c.index[r.Name]; ok {
+			return nil, fmt.Errorf("rank %s is listed twice", r.Name)
+		}
+		if r.Attack < 0 || r.Defense < 0 || r.Cost < 0 || r.Movement < 0 {
+			return nil, fmt.Errorf("rank %s has negative stats", r.Name)
+		}
+		for _, a := range r.Abilities {
+			if _, ok := knownAbilities[a]; !ok {
+				return nil, fmt.Errorf("rank %s has unknown ability %q", r.Name, a)
+			}
+		}
+		c.index[r.Name] = len(c.ranks)
+		c.ranks = append(c.ranks, r)
+	}
+	return c, nil
+}
+
+// DefaultRanks are infantry, cavalry and artillery as Peril has always had
+// them.
+func DefaultRanks() []RankStats {
+	return []RankStats{
+		{Name: RankInfantry, Attack: 1, Defense: 1, Cost: 1, Movement: 1},
+		{Name: RankCavalry, Attack: 5, Defense: 5, Cost: 3, Movement: 2},
+		{Name: RankArtillery, Attack: 10, Defense: 10, Cost: 5, Movement: 1},
+	}
+}
+
+// DefaultCatalog is the catalog of DefaultRanks.
+func DefaultCatalog() *Catalog {
+	c, err := NewCatalog(DefaultRanks())
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// ReadCatalogFile loads a unit catalog from a .json, .yaml or .yml file.
+func ReadCatalogFile(path string) (*Catalog, error) {
+	var def CatalogDefinition
+	if err := decodeFile(path, &def); err != nil {
+		return nil, fmt.Errorf("catalog file %s: %w", path, err)
+	}
+	c, err := NewCatalog(def.Ranks)
+	if err != nil {
+		return nil, fmt.Errorf("catalog file %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Stats looks up a rank.
+func (c *Catalog) Stats(rank UnitRank) (RankStats, bool) {
+	i, ok := c.index[rank]
+	if !ok {
+		return RankStats{}, false
+	}
+	return c.ranks[i], true
+}
+
+// Ranks returns every rank in the catalog.
+func (c *Catalog) Ranks() []RankStats {
+	return append([]RankStats(nil), c.ranks...)
+}
+
+// withDefenseBonus is c with n added to every unit's defense.
+func (c *Catalog) withDefenseBonus(n int) *Catalog {
+	bonused := *c
+	bonused.defenseBonus += n
+	return &bonused
+}
+
+// unitAttack is the strength a unit brings to a war it starts.
+func (c *Catalog) unitAttack(unit Unit) int {
+	stats, _ := c.Stats(unit.Rank)
+	return stats.Attack
+}
+
+// unitDefense is the strength a unit holds a territory with.
+func (c *Catalog) unitDefense(unit Unit) int {
+	stats, _ := c.Stats(unit.Rank)
+	if stats.Has(AbilityFortify) {
+		return 2*stats.Defense + c.defenseBonus
+	}
+	return stats.Defense + c.defenseBonus
+}
+
+func (c *Catalog) attackPower(units []Unit) int {
+	power := 0
+	for _, unit := range units {
+		power += c.unitAttack(unit)
+	}
+	return power
+}
+
+func (c *Catalog) defensePower(units []Unit) int {
+	power := 0
+	for _, unit := range units {
+		power += c.unitDefense(unit)
+	}
+	return power
+}
@@ -0,0 +1,120 @@
+package gamelogic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadCatalogFile(t *testing.T) {
+	c, err := ReadCatalogFile("../../units/modern.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []UnitRank
+	for _, r := range c.Ranks() {
+		names = append(names, r.Name)
+	}
+	if want := []UnitRank{"infantry", "cavalry", "artillery", "air", "bunker"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ranks are %v, want %v in file order", names, want)
+	}
+	if air, ok := c.Stats("air"); !ok || !air.Has(AbilityFlying) || air.Has(AbilityFortify) {
+		t.Errorf("air is %+v, want a flying rank", air)
+	}
+
+	c, err = ReadCatalogFile(writeFile(t, "scouts.json",
+		`{"ranks": [{"name": "scout", "attack": 1, "defense": 1, "cost": 1, "movement": 3}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scout, ok := c.Stats("scout"); !ok || scout.Movement != 3 {
+		t.Errorf("scout is %+v, %v, want one that moves 3", scout, ok)
+	}
+	if _, ok := c.Stats(RankInfantry); ok {
+		t.Error("a catalog without infantry has infantry")
+	}
+}
+
+func TestNewCatalogRejects(t *testing.T) {
+	infantry := RankStats{Name: RankInfantry, Attack: 1, Defense: 1, Cost: 1, Movement: 1}
+	tests := []struct {
+		name  string
+		ranks []RankStats
+		want  string
+	}{
+		{"no ranks", nil, "no ranks"},
+		{"no name", []RankStats{{Attack: 1}}, "without a name"},
+		{"duplicate rank", []RankStats{infantry, infantry}, "listed twice"},
+		{"negative stats", []RankStats{{Name: "ghost", Defense: -1}}, "negative stats"},
+		{"unknown ability", []RankStats{{Name: "mole", Abilities: []Ability{"burrowing"}}}, "burrowing"},
+	}
+	for _, tt := range tests {
+		if _, err := NewCatalog(tt.ranks); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error about %q", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := ReadCatalogFile(writeFile(t, "typo.yaml", "ranks:\n  - name: infantry\n    atack: 1\n")); err == nil {
+		t.Error("read a catalog file with an unknown field")
+	}
+}
+
+func TestUnknownRank(t *testing.T) {
+	gs := NewGameState("alice")
+	if err := gs.CommandSpawn([]string{"spawn", "europe", "dragon"}); err == nil {
+		t.Error("spawned a rank the catalog doesn't have")
+	}
+	if len(gs.getUnitsSnap()) != 0 {
+		t.Error("a failed spawn left a unit behind")
+	}
+}
+
+// abilityMap is the default map played with the default ranks plus a flying
+// and a fortified one.
+func abilityMap(t *testing.T) *WorldMap {
+	t.Helper()
+	def := DefaultMapDefinition()
+	def.Units = append(DefaultRanks(),
+		RankStats{Name: "air", Attack: 6, Defense: 2, Cost: 8, Movement: 1, Abilities: []Ability{AbilityFlying}},
+		RankStats{Name: "bunker", Attack: 0, Defense: 8, Cost: 4, Movement: 0, Abilities: []Ability{AbilityFortify}},
+	)
+	m, err := NewWorldMap(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestFlyingIgnoresBorders(t *testing.T) {
+	m := abilityMap(t)
+	air := []Unit{{ID: 1, Rank: "air"}}
+	if err := m.CheckMove("europe", "australia", air); err != nil {
+		t.Errorf("flying two borders away: %v", err)
+	}
+	if err := m.CheckMove("europe", "australia", append(air, Unit{ID: 2, Rank: RankInfantry})); err == nil {
+		t.Error("infantry flew along with the air unit")
+	}
+	if err := m.CheckMove("europe", "asia", []Unit{{ID: 3, Rank: "bunker"}}); err == nil {
+		t.Error("moved a bunker, which has no movement")
+	}
+}
+
+func TestFortifyDoublesDefense(t *testing.T) {
+	c := abilityMap(t).Units()
+	bunker := []Unit{{ID: 1, Rank: "bunker"}}
+	if got := c.defensePower(bunker); got != 16 {
+		t.Errorf("bunker defends with %d, want double its 8", got)
+	}
+	if got := c.attackPower(bunker); got != 0 {
+		t.Errorf("bunker attacks with %d, want its plain 0", got)
+	}
+	if got := c.withDefenseBonus(1).defensePower(bunker); got != 17 {
+		t.Errorf("bunker defends with %d in a held region, want 16+1", got)
+	}
+
+	// Artillery's 10 beats a bunker's 8, but not once it is fortified.
+	artillery := []Unit{{ID: 2, Rank: RankArtillery}}
+	if b := (PowerCombat{}).Fight(artillery, bunker, c); b.Winner != SideDefender {
+		t.Errorf("got %+v, want the bunker to hold", b)
+	}
+}
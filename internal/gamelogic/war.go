@@ -14,7 +14,27 @@ const (
 	WarOutcomeDraw
 )
 
-func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
+func (o WarOutcome) String() string {
+	switch o {
+	case WarOutcomeNotInvolved:
+		return "not_involved"
+	case WarOutcomeNoUnits:
+		return "no_units"
+	case WarOutcomeYouWon:
+		return "you_won"
+	case WarOutcomeOpponentWon:
+		return "opponent_won"
+	case WarOutcomeDraw:
+		return "draw"
+	default:
+		return fmt.Sprintf("WarOutcome(%d)", int(o))
+	}
+}
+
+// HandleWar resolves a war this player started. It changes nothing itself:
+// publish the result to the defender first, then apply it with
+// ApplyWarResult, so both sides lose exactly what the result says.
+func (gs *GameState) HandleWar(rw RecognitionOfWar) (WarOutcome, WarResult) {
 	defer fmt.Println("------------------------")
 	fmt.Println()
 	fmt.Println("==== War Declared ====")
@@ -24,18 +44,18 @@ func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner
 
 	if player.Username == rw.Defender.Username {
 		fmt.Printf("%s, you published the war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+		return WarOutcomeNotInvolved, WarResult{}
 	}
 
 	if player.Username != rw.Attacker.Username {
 		fmt.Printf("%s, you are not involved in this war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+		return WarOutcomeNotInvolved, WarResult{}
 	}
 
 	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
 	if overlappingLocation == "" {
 		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
-		return WarOutcomeNoUnits, "", ""
+		return WarOutcomeNoUnits, WarResult{}
 	}
 
 	attackerUnits := []Unit{}
@@ -59,47 +79,107 @@ func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner
 	for _, unit := range defenderUnits {
 		fmt.Printf("  * %v\n", unit.Rank)
 	}
-	attackerPower := unitsToPowerLevel(attackerUnits)
-	defenderPower := unitsToPowerLevel(defenderUnits)
-	fmt.Printf("Attacker has a power level of %v\n", attackerPower)
-	fmt.Printf("Defender has a power level of %v\n", defenderPower)
-	if attackerPower > defenderPower {
-		fmt.Printf("%s has won the war!\n", rw.Attacker.Username)
-		if player.Username == rw.Defender.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Attacker.Username, rw.Defender.Username
-		}
-		return WarOutcomeYouWon, rw.Attacker.Username, rw.Defender.Username
-	} else if defenderPower > attackerPower {
-		fmt.Printf("%s has won the war!\n", rw.Defender.Username)
-		if player.Username == rw.Attacker.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Defender.Username, rw.Attacker.Username
-		}
-		return WarOutcomeYouWon, rw.Defender.Username, rw.Attacker.Username
+	world := gs.Map()
+	catalog := world.Units()
+	defenderHolds := make([]Unit, 0, len(rw.Defender.Units))
+	for _, unit := range rw.Defender.Units {
+		defenderHolds = append(defenderHolds, unit)
+	}
+	if bonus := world.DefenseBonus(overlappingLocation, defenderHolds); bonus > 0 {
+		fmt.Printf("%s holds the region around %s: +%d defense per unit\n", rw.Defender.Username, overlappingLocation, bonus)
+		catalog = catalog.withDefenseBonus(bonus)
 	}
-	fmt.Println("The war ended in a draw!")
-	fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-	gs.removeUnitsInLocation(overlappingLocation)
-	return WarOutcomeDraw, rw.Attacker.Username, rw.Defender.Username
+	battle := gs.combat().Fight(attackerUnits, defenderUnits, catalog)
+	battle.print(rw.Attacker.Username, rw.Defender.Username)
+
+	result := WarResult{
+		Attacker:       rw.Attacker.Username,
+		Defender:       rw.Defender.Username,
+		Location:       overlappingLocation,
+		AttackerLosses: battle.AttackerLosses,
+		DefenderLosses: battle.DefenderLosses,
+	}
+	switch battle.Winner {
+	case SideAttacker:
+		result.Winner = rw.Attacker.Username
+	case SideDefender:
+		result.Winner = rw.Defender.Username
+	}
+	return result.outcomeFor(player.Username), result
 }
 
-func unitsToPowerLevel(units []Unit) int {
-	power := 0
-	for _, unit := range units {
-		if unit.Rank == RankArtillery {
-			power += 10
-		}
-		if unit.Rank == RankCavalry {
-			power += 5
+// ApplyWarResult removes this player's units that died in a war and returns
+// how the war went for them. Units that left the location before the result
+// arrived escaped. A result with a WarID is only applied once per run of the
+// game; the IDs of settled wars are not saved.
+func (gs *GameState) ApplyWarResult(wr WarResult) (outcome WarOutcome) {
+	defer fmt.Println("------------------------")
+	defer func() {
+		if outcome != WarOutcomeNotInvolved {
+			warsFought.With(outcome.String()).Inc()
 		}
-		if unit.Rank == RankInfantry {
-			power += 1
+	}()
+	fmt.Println()
+	fmt.Println("==== War Result ====")
+
+	username := gs.GetUsername()
+	var losses []Unit
+	switch username {
+	case wr.Attacker:
+		losses = wr.AttackerLosses
+	case wr.Defender:
+		losses = wr.DefenderLosses
+	default:
+		fmt.Printf("%s, you are not involved in this war.\n", username)
+		return WarOutcomeNotInvolved
+	}
+	if !gs.settleWar(wr.WarID) {
+		fmt.Println("This war was already settled.")
+		return WarOutcomeNotInvolved
+	}
+
+	dead := []Unit{}
+	for _, unit := range losses {
+		if current, ok := gs.GetUnit(unit.ID); ok && current.Location == wr.Location {
+			dead = append(dead, current)
 		}
 	}
-	return power
+	gs.removeUnits(dead)
+	if len(dead) > 0 {
+		fmt.Printf("%d of your units in %s have been killed.\n", len(dead), wr.Location)
+	}
+
+	outcome = wr.outcomeFor(username)
+	switch outcome {
+	case WarOutcomeYouWon:
+		fmt.Printf("You have won the war against %s!\n", wr.Loser())
+	case WarOutcomeOpponentWon:
+		fmt.Printf("You have lost the war against %s!\n", wr.Winner)
+	default:
+		fmt.Printf("The war between %s and %s ended in a draw!\n", wr.Attacker, wr.Defender)
+	}
+	return outcome
+}
+
+// Loser is the player who lost the war, or "" if it was a draw.
+func (wr WarResult) Loser() string {
+	switch wr.Winner {
+	case "":
+		return ""
+	case wr.Attacker:
+		return wr.Defender
+	default:
+		return wr.Attacker
+	}
+}
+
+func (wr WarResult) outcomeFor(username string) WarOutcome {
+	switch wr.Winner {
+	case "":
+		return WarOutcomeDraw
+	case username:
+		return WarOutcomeYouWon
+	default:
+		return WarOutcomeOpponentWon
+	}
 }
@@ -0,0 +1,160 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+// game is username's game on the default map with wars decided by mode,
+// and dice, if it rolls them, seeded with seed.
+func game(t *testing.T, username string, mode CombatMode, seed int64) *GameState {
+	t.Helper()
+	def := DefaultMapDefinition()
+	def.Combat = mode
+	world, err := NewWorldMap(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := NewGameState(username)
+	gs.SetMap(world)
+	gs.SetDice(NewSeededDiceCombat(seed))
+	return gs
+}
+
+func playerWith(username string, units ...Unit) Player {
+	p := Player{Username: username, Units: map[int]Unit{}}
+	for _, u := range units {
+		p.Units[u.ID] = u
+	}
+	return p
+}
+
+func TestHandleWar(t *testing.T) {
+	artillery := Unit{ID: 1, Rank: RankArtillery, Location: "asia"}
+	infantry := Unit{ID: 1, Rank: RankInfantry, Location: "asia"}
+	tests := []struct {
+		name           string
+		combat         CombatMode
+		player         string
+		attacker       Unit
+		defender       Unit
+		want           WarOutcome
+		winner         string
+		attackerLosses []Unit
+		defenderLosses []Unit
+	}{
+		{
+			name:           "attacker won",
+			combat:         CombatDice,
+			player:         "alice",
+			attacker:       artillery,
+			defender:       infantry,
+			want:           WarOutcomeYouWon,
+			winner:         "alice",
+			defenderLosses: []Unit{infantry},
+		},
+		{
+			name:           "defender won",
+			combat:         CombatDice,
+			player:         "alice",
+			attacker:       infantry,
+			defender:       artillery,
+			want:           WarOutcomeOpponentWon,
+			winner:         "bob",
+			attackerLosses: []Unit{infantry},
+		},
+		{
+			// Artillery hits on every roll, so both fall in the first round.
+			name:           "draw",
+			combat:         CombatDice,
+			player:         "alice",
+			attacker:       artillery,
+			defender:       artillery,
+			want:           WarOutcomeDraw,
+			attackerLosses: []Unit{artillery},
+			defenderLosses: []Unit{artillery},
+		},
+		{
+			name:     "no units",
+			combat:   CombatDice,
+			player:   "alice",
+			attacker: artillery,
+			defender: Unit{ID: 1, Rank: RankInfantry, Location: "europe"},
+			want:     WarOutcomeNoUnits,
+		},
+		{
+			name:     "not involved",
+			combat:   CombatDice,
+			player:   "carol",
+			attacker: artillery,
+			defender: infantry,
+			want:     WarOutcomeNotInvolved,
+		},
+		{
+			name:     "your own war",
+			combat:   CombatDice,
+			player:   "bob",
+			attacker: artillery,
+			defender: infantry,
+			want:     WarOutcomeNotInvolved,
+		},
+		{
+			name:           "power: attacker won",
+			combat:         CombatPower,
+			player:         "alice",
+			attacker:       artillery,
+			defender:       infantry,
+			want:           WarOutcomeYouWon,
+			winner:         "alice",
+			defenderLosses: []Unit{infantry},
+		},
+		{
+			name:           "power: defender won",
+			combat:         CombatPower,
+			player:         "alice",
+			attacker:       infantry,
+			defender:       artillery,
+			want:           WarOutcomeOpponentWon,
+			winner:         "bob",
+			attackerLosses: []Unit{infantry},
+		},
+		{
+			// Equal power is a draw that costs both sides everything.
+			name:           "power: tie",
+			combat:         CombatPower,
+			player:         "alice",
+			attacker:       artillery,
+			defender:       artillery,
+			want:           WarOutcomeDraw,
+			attackerLosses: []Unit{artillery},
+			defenderLosses: []Unit{artillery},
+		},
+		{
+			name:     "power: not involved",
+			combat:   CombatPower,
+			player:   "carol",
+			attacker: artillery,
+			defender: infantry,
+			want:     WarOutcomeNotInvolved,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := game(t, tt.player, tt.combat, 1)
+			outcome, result := gs.HandleWar(RecognitionOfWar{
+				Attacker: playerWith("alice", tt.attacker),
+				Defender: playerWith("bob", tt.defender),
+			})
+			if outcome != tt.want {
+				t.Fatalf("got %v, want %v", outcome, tt.want)
+			}
+			if result.Winner != tt.winner {
+				t.Errorf("winner is %q, want %q", result.Winner, tt.winner)
+			}
+			if !reflect.DeepEqual(result.AttackerLosses, tt.attackerLosses) || !reflect.DeepEqual(result.DefenderLosses, tt.defenderLosses) {
+				t.Errorf("losses are %v and %v, want %v and %v",
+					result.AttackerLosses, result.DefenderLosses, tt.attackerLosses, tt.defenderLosses)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// WorldMap is the board: the locations units can be in and which of them
+// border each other. Units only move along borders.
+type WorldMap struct {
+	def      MapDefinition
+	adjacent map[Location]map[Location]struct{}
+	start    map[Location]struct{}
+	units    *Catalog
+}
+
+// NewWorldMap checks def and builds the map it describes.
+func NewWorldMap(def MapDefinition) (*WorldMap, error) {
+	if len(def.Territories) == 0 {
+		return nil, errors.New("map has no territories")
+	}
+	m := &WorldMap{
+		def:      def,
+		adjacent: map[Location]map[Location]struct{}{},
+		start:    map[Location]struct{}{},
+	}
+	for _, t := range def.Territories {
+		if t.Name == "" {
+			return nil, errors.New("map has a territory without a name")
+		}
+		if m.Has(t.Name) {
+			return nil, fmt.Errorf("territory %s is listed twice", t.Name)
+		}
+		m.adjacent[t.Name] = map[Location]struct{}{}
+	}
+	for _, t := range def.Territories {
+		for _, n := range t.Borders {
+			if !m.Has(n) {
+				return nil, fmt.Errorf("territory %s borders %s, which is not on the map", t.Name, n)
+			}
+			if n == t.Name {
+				return nil, fmt.Errorf("territory %s borders itself", t.Name)
+			}
+			m.adjacent[t.Name][n] = struct{}{}
+			m.adjacent[n][t.Name] = struct{}{}
+		}
+	}
+	for _, r := range def.Regions {
+		for _, loc := range r.Territories {
+			if !m.Has(loc) {
+				return nil, fmt.Errorf("region %s includes %s, which is not on the map", r.Name, loc)
+			}
+		}
+	}
+	for _, loc := range def.Start {
+		if !m.Has(loc) {
+			return nil, fmt.Errorf("starting position %s is not on the map", loc)
+		}
+		m.start[loc] = struct{}{}
+	}
+	switch def.Combat {
+	case "", CombatPower, CombatDice:
+	default:
+		return nil, fmt.Errorf("unknown combat mode %q, want %s or %s", def.Combat, CombatPower, CombatDice)
+	}
+	m.units = DefaultCatalog()
+	if len(def.Units) > 0 {
+		units, err := NewCatalog(def.Units)
+		if err != nil {
+			return nil, err
+		}
+		m.units = units
+	}
+	return m, nil
+}
+
+// DefaultMapDefinition is the six continents Peril has always been played
+// on.
+func DefaultMapDefinition() MapDefinition {
+	return MapDefinition{
+		Name: "classic",
+		Territories: []Territory{
+			{Name: "americas", Borders: []Location{"europe", "africa", "asia", "antarctica"}},
+			{Name: "europe", Borders: []Location{"africa", "asia"}},
+			{Name: "africa", Borders: []Location{"asia", "antarctica"}},
+			{Name: "asia", Borders: []Location{"australia"}},
+			{Name: "australia", Borders: []Location{"antarctica"}},
+			{Name: "antarctica"},
+		},
+	}
+}
+
+// DefaultMap is the map built from DefaultMapDefinition.
+func DefaultMap() *WorldMap {
+	m, err := NewWorldMap(DefaultMapDefinition())
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Name is the map's name.
+func (m *WorldMap) Name() string {
+	return m.def.Name
+}
+
+// Units is the catalog of ranks played with on the map.
+func (m *WorldMap) Units() *Catalog {
+	return m.units
+}
+
+// Combat is how wars are decided on the map.
+func (m *WorldMap) Combat() CombatMode {
+	if m.def.Combat == "" {
+		return CombatPower
+	}
+	return m.def.Combat
+}
+
+// Definition returns what the map was built from.
+func (m *WorldMap) Definition() MapDefinition {
+	return m.def
+}
+
+// Has reports whether loc is on the map.
+func (m *WorldMap) Has(loc Location) bool {
+	_, ok := m.adjacent[loc]
+	return ok
+}
+
+// Locations returns every location, sorted.
+func (m *WorldMap) Locations() []Location {
+	locs := make([]Location, 0, len(m.adjacent))
+	for loc := range m.adjacent {
+		locs = append(locs, loc)
+	}
+	sort.Slice(locs, func(i, j int) bool { return locs[i] < locs[j] })
+	return locs
+}
+
+// Neighbours returns the locations bordering loc, sorted.
+func (m *WorldMap) Neighbours(loc Location) []Location {
+	locs := make([]Location, 0, len(m.adjacent[loc]))
+	for n := range m.adjacent[loc] {
+		locs = append(locs, n)
+	}
+	sort.Slice(locs, func(i, j int) bool { return locs[i] < locs[j] })
+	return locs
+}
+
+// Path returns the shortest route from one location to another, both ends
+// included, or nil if there is none.
+func (m *WorldMap) Path(from, to Location) []Location {
+	if !m.Has(from) || !m.Has(to) {
+		return nil
+	}
+	prev := map[Location]Location{from: ""}
+	queue := []Location{from}
+	for len(queue) > 0 {
+		loc := queue[0]
+		queue = queue[1:]
+		if loc == to {
+			path := []Location{}
+			for ; loc != ""; loc = prev[loc] {
+				path = append([]Location{loc}, path...)
+			}
+			return path
+		}
+		for _, n := range m.Neighbours(loc) {
+			if _, seen := prev[n]; !seen {
+				prev[n] = loc
+				queue = append(queue, n)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckMove returns why units moving together from one location to another
+// can't make it in one move, or nil if they can. The slowest unit sets the
+// pace; flying units reach anywhere in one step.
+func (m *WorldMap) CheckMove(from, to Location, units []Unit) error {
+	if !m.Has(from) {
+		return fmt.Errorf("error: %s is not a valid location", from)
+	}
+	if !m.Has(to) {
+		return fmt.Errorf("error: %s is not a valid location", to)
+	}
+	if from == to {
+		return fmt.Errorf("error: units are already in %s", to)
+	}
+	path := m.Path(from, to)
+	for _, unit := range units {
+		stats, ok := m.units.Stats(unit.Rank)
+		if !ok {
+			return fmt.Errorf("error: %s is not a valid unit", unit.Rank)
+		}
+		if stats.Movement < 1 {
+			return fmt.Errorf("error: %s can't move", unit.Rank)
+		}
+		if stats.Has(AbilityFlying) {
+			continue
+		}
+		if path == nil {
+			return fmt.Errorf("error: there is no way from %s to %s", from, to)
+		}
+		if steps := len(path) - 1; stats.Movement < steps {
+			return fmt.Errorf("error: %s is %d steps from %s (via %v), but %s can only move %d",
+				to, steps, from, path[1:len(path)-1], unit.Rank, stats.Movement)
+		}
+	}
+	return nil
+}
+
+// CheckSpawn returns why a player holding the given units can't spawn one
+// in loc, or nil if they can. On maps with starting positions, units spawn
+// there or where the player already has units.
+func (m *WorldMap) CheckSpawn(loc Location, units []Unit) error {
+	if !m.Has(loc) {
+		return fmt.Errorf("error: %s is not a valid location", loc)
+	}
+	if len(m.start) == 0 {
+		return nil
+	}
+	if _, ok := m.start[loc]; ok {
+		return nil
+	}
+	for _, u := range units {
+		if u.Location == loc {
+			return nil
+		}
+	}
+	return fmt.Errorf("error: units can only spawn in a starting position %v or where you already have units", m.def.Start)
+}
+
+// Bonus returns the regions a player holding units controls entirely, and
+// the total bonus they are worth.
+func (m *WorldMap) Bonus(units []Unit) ([]Region, int) {
+	held := map[Location]struct{}{}
+	for _, u := range units {
+		held[u.Location] = struct{}{}
+	}
+	var regions []Region
+	total := 0
+	for _, r := range m.def.Regions {
+		all := len(r.Territories) > 0
+		for _, loc := range r.Territories {
+			if _, ok := held[loc]; !ok {
+				all = false
+				break
+			}
+		}
+		if all {
+			regions = append(regions, r)
+			total += r.Bonus
+		}
+	}
+	return regions, total
+}
+
+// DefenseBonus is what each unit defending loc adds to its defense, for a
+// player holding units: the bonuses of the regions around loc they hold
+// entirely.
+func (m *WorldMap) DefenseBonus(loc Location, units []Unit) int {
+	regions, _ := m.Bonus(units)
+	bonus := 0
+	for _, r := range regions {
+		for _, t := range r.Territories {
+			if t == loc {
+				bonus += r.Bonus
+				break
+			}
+		}
+	}
+	return bonus
+}
+
+// HandleMap switches the game to the map the server sent.
+func (gs *GameState) HandleMap(def MapDefinition) error {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	m, err := NewWorldMap(def)
+	if err != nil {
+		fmt.Printf("The server sent a broken map: %v\n", err)
+		return err
+	}
+	gs.SetMap(m)
+	fmt.Printf("==== Playing on the %s map ====\n", m.Name())
+	fmt.Printf("Territories: %v\n", m.Locations())
+	for _, unit := range gs.getUnitsSnap() {
+		if !m.Has(unit.Location) {
+			fmt.Printf("Unit %v is in %s, which is not on this map.\n", unit.ID, unit.Location)
+		}
+	}
+	return nil
+}
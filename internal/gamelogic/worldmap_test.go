@@ -0,0 +1,152 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	m := DefaultMap()
+	tests := []struct {
+		from, to Location
+		want     []Location
+	}{
+		{"europe", "asia", []Location{"europe", "asia"}},
+		{"europe", "australia", []Location{"europe", "asia", "australia"}},
+		{"asia", "asia", []Location{"asia"}},
+		{"europe", "atlantis", nil},
+	}
+	for _, tt := range tests {
+		if got := m.Path(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Path(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestCheckMove(t *testing.T) {
+	m := DefaultMap()
+	infantry := []Unit{{ID: 1, Rank: RankInfantry}}
+	cavalry := []Unit{{ID: 2, Rank: RankCavalry}}
+	tests := []struct {
+		name     string
+		from, to Location
+		units    []Unit
+		legal    bool
+	}{
+		{"adjacent", "europe", "asia", infantry, true},
+		{"not adjacent", "europe", "australia", infantry, false},
+		{"two steps for cavalry", "europe", "australia", cavalry, true},
+		{"slowest sets the pace", "europe", "australia", append(cavalry, infantry...), false},
+		{"unknown destination", "europe", "atlantis", infantry, false},
+		{"unknown origin", "atlantis", "europe", infantry, false},
+		{"staying put", "europe", "europe", infantry, false},
+		{"unknown rank", "europe", "asia", []Unit{{ID: 3, Rank: "dragon"}}, false},
+	}
+	for _, tt := range tests {
+		if err := m.CheckMove(tt.from, tt.to, tt.units); (err == nil) != tt.legal {
+			t.Errorf("%s: got %v, want legal %v", tt.name, err, tt.legal)
+		}
+	}
+}
+
+func TestCheckSpawn(t *testing.T) {
+	def := DefaultMapDefinition()
+	def.Start = []Location{"europe"}
+	m, err := NewWorldMap(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held := []Unit{{ID: 1, Rank: RankInfantry, Location: "asia"}}
+	tests := []struct {
+		name  string
+		loc   Location
+		legal bool
+	}{
+		{"starting position", "europe", true},
+		{"where units are", "asia", true},
+		{"anywhere else", "africa", false},
+		{"unknown location", "atlantis", false},
+	}
+	for _, tt := range tests {
+		if err := m.CheckSpawn(tt.loc, held); (err == nil) != tt.legal {
+			t.Errorf("%s: got %v, want legal %v", tt.name, err, tt.legal)
+		}
+	}
+	if err := DefaultMap().CheckSpawn("africa", nil); err != nil {
+		t.Errorf("spawning on a map without starting positions: %v", err)
+	}
+}
+
+func TestHandleMap(t *testing.T) {
+	gs := NewGameState("alice")
+	def := MapDefinition{
+		Name: "pair",
+		Territories: []Territory{
+			{Name: "left", Borders: []Location{"right"}},
+			{Name: "right"},
+		},
+	}
+	if err := gs.HandleMap(def); err != nil {
+		t.Fatal(err)
+	}
+	if got := gs.Map().Name(); got != "pair" {
+		t.Fatalf("playing on %s, want pair", got)
+	}
+	if err := gs.Map().CheckMove("left", "right", []Unit{{Rank: RankInfantry}}); err != nil {
+		t.Errorf("moving along the new map's border: %v", err)
+	}
+
+	// A broken map is refused and the game stays on the last good one.
+	broken := MapDefinition{Name: "broken", Territories: []Territory{{Name: "island", Borders: []Location{"nowhere"}}}}
+	if err := gs.HandleMap(broken); err == nil {
+		t.Error("switched to a map with a border to nowhere")
+	}
+	if got := gs.Map().Name(); got != "pair" {
+		t.Errorf("playing on %s after a broken map, want pair", got)
+	}
+}
+
+func TestRegionBonusDefends(t *testing.T) {
+	def := DefaultMapDefinition()
+	def.Regions = []Region{{Name: "east", Territories: []Location{"asia", "australia"}, Bonus: 5}}
+	world, err := NewWorldMap(def)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defender := playerWith("bob",
+		Unit{ID: 1, Rank: RankInfantry, Location: "asia"},
+		Unit{ID: 2, Rank: RankInfantry, Location: "australia"},
+	)
+	if got := world.DefenseBonus("asia", unitsOf(defender)); got != 5 {
+		t.Errorf("bonus in asia is %d, want the region's 5", got)
+	}
+	if got := world.DefenseBonus("europe", unitsOf(defender)); got != 0 {
+		t.Errorf("bonus in europe is %d, want none outside the region", got)
+	}
+
+	// Cavalry beats infantry 5 to 1, but infantry in a region its player
+	// holds defends with 1+5.
+	attacker := playerWith("alice", Unit{ID: 1, Rank: RankCavalry, Location: "asia"})
+	gs := NewGameState("alice")
+	gs.SetMap(world)
+	outcome, result := gs.HandleWar(RecognitionOfWar{Attacker: attacker, Defender: defender})
+	if outcome != WarOutcomeOpponentWon {
+		t.Errorf("got %v, want the defender to hold asia with the region bonus", outcome)
+	}
+	if len(result.AttackerLosses) != 1 || len(result.DefenderLosses) != 0 {
+		t.Errorf("losses are %v and %v, want only the cavalry", result.AttackerLosses, result.DefenderLosses)
+	}
+
+	delete(defender.Units, 2)
+	if outcome, _ := gs.HandleWar(RecognitionOfWar{Attacker: attacker, Defender: defender}); outcome != WarOutcomeYouWon {
+		t.Errorf("got %v, want the attacker to win once the region is broken", outcome)
+	}
+}
+
+func unitsOf(p Player) []Unit {
+	units := make([]Unit, 0, len(p.Units))
+	for _, u := range p.Units {
+		units = append(units, u)
+	}
+	return units
+}
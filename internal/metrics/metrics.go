@@ -0,0 +1,384 @@
+// Package metrics keeps counters, gauges and histograms and serves them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets suit handler latencies in seconds, from 1ms to 10s.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds a set of metric families.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+// Default is the registry the package-level constructors register with.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]family{}}
+}
+
+type family interface {
+	write(w io.Writer, name string) error
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	r.families[name] = f
+}
+
+// Write writes every metric in r to w in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	r.mu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		r.mu.Lock()
+		f := r.families[name]
+		r.mu.Unlock()
+		if err := f.write(w, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves r on /metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// ListenAndServe serves the Default registry at addr until it fails.
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Default.Handler())
+	return http.ListenAndServe(addr, mux)
+}
+
+// vec is the set of series in one family, keyed by their label values.
+type vec[S any] struct {
+	help   string
+	kind   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*S
+	values map[string][]string
+	create func() *S
+}
+
+func newVec[S any](help, kind string, labels []string, create func() *S) *vec[S] {
+	return &vec[S]{
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: map[string]*S{},
+		values: map[string][]string{},
+		create: create,
+	}
+}
+
+func (v *vec[S]) with(values []string) *S {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: got %d label values for %d labels", len(values), len(v.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = v.create()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// each calls f for every series in label order.
+func (v *vec[S]) each(f func(values []string, s *S) error) error {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	v.mu.Unlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		v.mu.Lock()
+		s, values := v.series[key], v.values[key]
+		v.mu.Unlock()
+		if err := f(values, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *vec[S]) header(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(v.help), name, v.kind)
+	return err
+}
+
+// The exposition format escapes backslashes and newlines in help text, and
+// double quotes as well in label values. Nothing else is escaped, unlike Go
+// string literals.
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
+
+func formatLabels(names, values []string, extra ...string) string {
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, name+"="+quoteLabel(values[i]))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+quoteLabel(extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increases the counter by delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter decreased")
+	}
+	c.mu.Lock()
+	c.value += delta
+	c.mu.Unlock()
+}
+
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// CounterVec is a family of counters split by labels.
+type CounterVec struct {
+	*vec[Counter]
+}
+
+// NewCounter registers a counter family with the Default registry.
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounter(name, help, labels...)
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(help, "counter", labels, func() *Counter { return &Counter{} })}
+	r.register(name, c)
+	return c
+}
+
+// With returns the counter for the given label values, in label order.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values)
+}
+
+func (c *CounterVec) write(w io.Writer, name string) error {
+	if err := c.header(w, name); err != nil {
+		return err
+	}
+	return c.each(func(values []string, s *Counter) error {
+		_, err := fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(c.labels, values), formatFloat(s.Value()))
+		return err
+	})
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	mu    sync.Mutex
+	value float64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.mu.Lock()
+	g.value += delta
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+// GaugeVec is a family of gauges split by labels.
+type GaugeVec struct {
+	*vec[Gauge]
+}
+
+// NewGauge registers a gauge family with the Default registry.
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGauge(name, help, labels...)
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(help, "gauge", labels, func() *Gauge { return &Gauge{} })}
+	r.register(name, g)
+	return g
+}
+
+// With returns the gauge for the given label values, in label order.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.with(values)
+}
+
+func (g *GaugeVec) write(w io.Writer, name string) error {
+	if err := g.header(w, name); err != nil {
+		return err
+	}
+	return g.each(func(values []string, s *Gauge) error {
+		_, err := fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(g.labels, values), formatFloat(s.Value()))
+		return err
+	})
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Count is how many values have been observed.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// ObserveDuration records d in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// HistogramVec is a family of histograms split by labels.
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+// NewHistogram registers a histogram family with the Default registry. Nil
+// buckets mean DefaultBuckets.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{newVec(help, "histogram", labels, func() *Histogram {
+		return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	})}
+	r.register(name, h)
+	return h
+}
+
+// With returns the histogram for the given label values, in label order.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+func (h *HistogramVec) write(w io.Writer, name string) error {
+	if err := h.header(w, name); err != nil {
+		return err
+	}
+	return h.each(func(values []string, s *Histogram) error {
+		s.mu.Lock()
+		counts, count, sum := append([]uint64(nil), s.counts...), s.count, s.sum
+		s.mu.Unlock()
+		for i, upper := range s.buckets {
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(h.labels, values, "le", formatFloat(upper)), counts[i]); err != nil {
+				return err
+			}
+		}
+		labels := formatLabels(h.labels, values)
+		_, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			name, formatLabels(h.labels, values, "le", "+Inf"), count,
+			name, labels, formatFloat(sum),
+			name, labels, count,
+		)
+		return err
+	})
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteExposition(t *testing.T) {
+	r := NewRegistry()
+	published := r.NewCounter("published_total", "Messages published.", "key")
+	depth := r.NewGauge("queue_messages", "Ready messages.\nPolled.", "queue")
+	duration := r.NewHistogram("handler_seconds", `Handler time, in C:\seconds.`, []float64{1, 0.5}, "queue")
+
+	published.With("army_moves.bob").Add(2)
+	published.With("a\"b").Inc()
+	depth.With("café\\\n").Set(3)
+	duration.With("moves").Observe(0.25)
+	duration.With("moves").Observe(2)
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP handler_seconds Handler time, in C:\\seconds.
+# TYPE handler_seconds histogram
+handler_seconds_bucket{queue="moves",le="0.5"} 1
+handler_seconds_bucket{queue="moves",le="1"} 1
+handler_seconds_bucket{queue="moves",le="+Inf"} 2
+handler_seconds_sum{queue="moves"} 2.25
+handler_seconds_count{queue="moves"} 2
+# HELP published_total Messages published.
+# TYPE published_total counter
+published_total{key="a\"b"} 1
+published_total{key="army_moves.bob"} 2
+# HELP queue_messages Ready messages.\nPolled.
+# TYPE queue_messages gauge
+queue_messages{queue="café\\\n"} 3
+`
+	if got := b.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,282 @@
+// Package outbox keeps publishes that have been promised but not yet
+// confirmed in an append-only file, so they survive a crash or a lost
+// connection and can be sent again.
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is one message waiting to be published. Headers come back from the
+// journal with whole numbers as int64 and other numbers as float64. Parked is
+// why the entry was given up on, and empty while it is pending.
+type Entry struct {
+	ID            string         `json:"id"`
+	Exchange      string         `json:"exchange"`
+	Key           string         `json:"key"`
+	ContentType   string         `json:"content_type,omitempty"`
+	CorrelationID string         `json:"correlation_id,omitempty"`
+	AppID         string         `json:"app_id,omitempty"`
+	Timestamp     time.Time      `json:"timestamp"`
+	Headers       map[string]any `json:"headers,omitempty"`
+	Body          []byte         `json:"body"`
+	Parked        string         `json:"parked,omitempty"`
+}
+
+// record is one line of the journal: an entry being added, the ID of one
+// that has been published, or the ID of one that was parked and why.
+type record struct {
+	Add    *Entry `json:"add,omitempty"`
+	Done   string `json:"done,omitempty"`
+	Park   string `json:"park,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// compactAfter is how many published entries the journal holds before it is
+// rewritten without them.
+const compactAfter = 1000
+
+var ErrClosed = errors.New("outbox journal closed")
+
+// Journal is a file of pending entries, and of parked ones that will never
+// be published but are kept for someone to look at. Every change is synced
+// to disk before the call that makes it returns.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	pending []Entry
+	parked  []Entry
+	done    int
+}
+
+// Open reads the journal at path, creating it if needed. A partly written
+// last line, left by a crash mid-append, is dropped.
+func Open(path string) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{path: path, f: f}
+	if err := j.load(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("could not read outbox journal %s: %w", path, err)
+	}
+	if err := j.compact(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) load() error {
+	r := bufio.NewReader(j.f)
+	byID := map[string]int{}
+	var entries []Entry
+	var published []bool
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Anything after the last newline was never fully written.
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var rec record
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.UseNumber()
+		if err := dec.Decode(&rec); err != nil {
+			return err
+		}
+		switch {
+		case rec.Add != nil:
+			rec.Add.Headers = fromJSONNumbers(rec.Add.Headers)
+			byID[rec.Add.ID] = len(entries)
+			entries = append(entries, *rec.Add)
+			published = append(published, false)
+		case rec.Done != "":
+			if i, ok := byID[rec.Done]; ok {
+				published[i] = true
+			}
+		case rec.Park != "":
+			if i, ok := byID[rec.Park]; ok {
+				entries[i].Parked = rec.Reason
+			}
+		}
+	}
+	for i, e := range entries {
+		switch {
+		case published[i]:
+		case e.Parked != "":
+			j.parked = append(j.parked, e)
+		default:
+			j.pending = append(j.pending, e)
+		}
+	}
+	return nil
+}
+
+func fromJSONNumbers(headers map[string]any) map[string]any {
+	for k, v := range headers {
+		n, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+		if i, err := n.Int64(); err == nil {
+			headers[k] = i
+		} else if f, err := n.Float64(); err == nil {
+			headers[k] = f
+		}
+	}
+	return headers
+}
+
+// Append records entries as pending.
+func (j *Journal) Append(entries ...Entry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for i := range entries {
+		if err := enc.Encode(record{Add: &entries[i]}); err != nil {
+			return err
+		}
+	}
+	if err := j.write(buf.Bytes()); err != nil {
+		return err
+	}
+	j.pending = append(j.pending, entries...)
+	return nil
+}
+
+// Done marks the entry with id as published.
+func (j *Journal) Done(id string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	line, err := json.Marshal(record{Done: id})
+	if err != nil {
+		return err
+	}
+	if err := j.write(append(line, '\n')); err != nil {
+		return err
+	}
+	j.remove(id)
+	j.done++
+	if j.done >= compactAfter {
+		return j.compact()
+	}
+	return nil
+}
+
+// Park takes the entry with id out of the pending ones and keeps it, with
+// reason, among the parked.
+func (j *Journal) Park(id, reason string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	line, err := json.Marshal(record{Park: id, Reason: reason})
+	if err != nil {
+		return err
+	}
+	if err := j.write(append(line, '\n')); err != nil {
+		return err
+	}
+	if e, ok := j.remove(id); ok {
+		e.Parked = reason
+		j.parked = append(j.parked, e)
+	}
+	return nil
+}
+
+// remove takes the entry with id out of the pending ones.
+func (j *Journal) remove(id string) (Entry, bool) {
+	for i, e := range j.pending {
+		if e.ID == id {
+			j.pending = append(j.pending[:i:i], j.pending[i+1:]...)
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Pending returns the entries not yet published, oldest first.
+func (j *Journal) Pending() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]Entry(nil), j.pending...)
+}
+
+// Parked returns the entries that were given up on, oldest first.
+func (j *Journal) Parked() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]Entry(nil), j.parked...)
+}
+
+func (j *Journal) write(b []byte) error {
+	if j.f == nil {
+		return ErrClosed
+	}
+	if _, err := j.f.Write(b); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+// compact rewrites the journal with only the parked and pending entries. The
+// new file replaces the old one by rename, so a crash leaves one or the
+// other.
+func (j *Journal) compact() error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, entries := range [][]Entry{j.parked, j.pending} {
+		for i := range entries {
+			if err := enc.Encode(record{Add: &entries[i]}); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		f.Close()
+		return err
+	}
+	j.f.Close()
+	j.f = f
+	j.done = 0
+	return nil
+}
+
+// Close closes the journal file. Pending entries stay in it for the next
+// Open.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
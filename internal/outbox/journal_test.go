@@ -0,0 +1,133 @@
+package outbox
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func ids(entries []Entry) []string {
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.ID)
+	}
+	return got
+}
+
+func openJournal(t *testing.T, path string) *Journal {
+	t.Helper()
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+func TestJournalReopensWithPending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peril.outbox")
+	j := openJournal(t, path)
+	err := j.Append(
+		Entry{ID: "a", Key: "k", Headers: map[string]any{"x-peril-schema-version": int64(1), "ratio": 0.5}},
+		Entry{ID: "b", Key: "k"},
+		Entry{ID: "c", Key: "k"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Done("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Park("c", "unroutable"); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	j = openJournal(t, path)
+	if got := ids(j.Pending()); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("pending %v, want [a]", got)
+	}
+	if got := j.Parked(); len(got) != 1 || got[0].ID != "c" || got[0].Parked != "unroutable" {
+		t.Errorf("parked %+v, want c with its reason", got)
+	}
+	want := map[string]any{"x-peril-schema-version": int64(1), "ratio": 0.5}
+	if got := j.Pending()[0].Headers; !reflect.DeepEqual(got, want) {
+		t.Errorf("headers %#v, want %#v", got, want)
+	}
+}
+
+func TestJournalIgnoresTruncatedLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peril.outbox")
+	j := openJournal(t, path)
+	if err := j.Append(Entry{ID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	// A crash mid-append leaves half a line behind.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"add":{"id":"b","exch`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	j = openJournal(t, path)
+	if got := ids(j.Pending()); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("pending %v, want [a]", got)
+	}
+	if err := j.Append(Entry{ID: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	j = openJournal(t, path)
+	if got := ids(j.Pending()); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Errorf("pending %v, want [a c]", got)
+	}
+}
+
+func TestJournalCompactsToUnsentAndParked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peril.outbox")
+	j := openJournal(t, path)
+	entries := make([]Entry, compactAfter+2)
+	for i := range entries {
+		entries[i] = Entry{ID: fmt.Sprint(i)}
+	}
+	if err := j.Append(entries...); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Park("0", "unroutable"); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries[1 : compactAfter+1] {
+		if err := j.Done(e.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The last Done rewrote the file with the parked entry and the one
+	// still pending.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != 2 {
+		t.Errorf("%d lines after compacting, want 2", n)
+	}
+	j.Close()
+
+	j = openJournal(t, path)
+	last := fmt.Sprint(compactAfter + 1)
+	if got := ids(j.Pending()); !reflect.DeepEqual(got, []string{last}) {
+		t.Errorf("pending %v, want [%s]", got, last)
+	}
+	if got := ids(j.Parked()); !reflect.DeepEqual(got, []string{"0"}) {
+		t.Errorf("parked %v, want [0]", got)
+	}
+}
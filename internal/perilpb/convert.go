@@ -0,0 +1,232 @@
+package perilpb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Marshal encodes a Peril message struct, or a pointer to one, in its
+// protobuf wire form. Any proto.Message is encoded as is.
+func Marshal(v any) ([]byte, error) {
+	var m proto.Message
+	switch val := v.(type) {
+	case proto.Message:
+		m = val
+	case gamelogic.ArmyMove:
+		m = FromArmyMove(val)
+	case *gamelogic.ArmyMove:
+		m = FromArmyMove(*val)
+	case gamelogic.RecognitionOfWar:
+		m = FromRecognitionOfWar(val)
+	case *gamelogic.RecognitionOfWar:
+		m = FromRecognitionOfWar(*val)
+	case gamelogic.WarResult:
+		m = FromWarResult(val)
+	case *gamelogic.WarResult:
+		m = FromWarResult(*val)
+	case routing.PlayingState:
+		m = FromPlayingState(val)
+	case *routing.PlayingState:
+		m = FromPlayingState(*val)
+	case routing.GameLog:
+		m = FromGameLog(val)
+	case *routing.GameLog:
+		m = FromGameLog(*val)
+	default:
+		return nil, fmt.Errorf("perilpb: no protobuf schema for %T", v)
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal decodes data into a pointer to a Peril message struct or into a
+// proto.Message.
+func Unmarshal(data []byte, v any) error {
+	switch out := v.(type) {
+	case proto.Message:
+		return proto.Unmarshal(data, out)
+	case *gamelogic.ArmyMove:
+		var m ArmyMove
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*out = ToArmyMove(&m)
+	case *gamelogic.RecognitionOfWar:
+		var m RecognitionOfWar
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*out = ToRecognitionOfWar(&m)
+	case *gamelogic.WarResult:
+		var m WarResult
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*out = ToWarResult(&m)
+	case *routing.PlayingState:
+		var m PlayingState
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*out = ToPlayingState(&m)
+	case *routing.GameLog:
+		var m GameLog
+		if err := proto.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		*out = ToGameLog(&m)
+	default:
+		return fmt.Errorf("perilpb: no protobuf schema for %T", v)
+	}
+	return nil
+}
+
+func FromUnit(u gamelogic.Unit) *Unit {
+	return &Unit{
+		Id:       int64(u.ID),
+		Rank:     string(u.Rank),
+		Location: string(u.Location),
+	}
+}
+
+func ToUnit(m *Unit) gamelogic.Unit {
+	return gamelogic.Unit{
+		ID:       int(m.GetId()),
+		Rank:     gamelogic.UnitRank(m.GetRank()),
+		Location: gamelogic.Location(m.GetLocation()),
+	}
+}
+
+func FromPlayer(p gamelogic.Player) *Player {
+	units := make(map[int64]*Unit, len(p.Units))
+	for id, u := range p.Units {
+		units[int64(id)] = FromUnit(u)
+	}
+	return &Player{
+		Username: p.Username,
+		Units:    units,
+	}
+}
+
+func ToPlayer(m *Player) gamelogic.Player {
+	units := make(map[int]gamelogic.Unit, len(m.GetUnits()))
+	for id, u := range m.GetUnits() {
+		units[int(id)] = ToUnit(u)
+	}
+	return gamelogic.Player{
+		Username: m.GetUsername(),
+		Units:    units,
+	}
+}
+
+func FromArmyMove(am gamelogic.ArmyMove) *ArmyMove {
+	units := make([]*Unit, 0, len(am.Units))
+	for _, u := range am.Units {
+		units = append(units, FromUnit(u))
+	}
+	return &ArmyMove{
+		Player:       FromPlayer(am.Player),
+		Units:        units,
+		ToLocation:   string(am.ToLocation),
+		FromLocation: string(am.FromLocation),
+	}
+}
+
+func ToArmyMove(m *ArmyMove) gamelogic.ArmyMove {
+	units := make([]gamelogic.Unit, 0, len(m.GetUnits()))
+	for _, u := range m.GetUnits() {
+		units = append(units, ToUnit(u))
+	}
+	return gamelogic.ArmyMove{
+		Player:       ToPlayer(m.GetPlayer()),
+		Units:        units,
+		ToLocation:   gamelogic.Location(m.GetToLocation()),
+		FromLocation: gamelogic.Location(m.GetFromLocation()),
+	}
+}
+
+func FromRecognitionOfWar(rw gamelogic.RecognitionOfWar) *RecognitionOfWar {
+	return &RecognitionOfWar{
+		Attacker: FromPlayer(rw.Attacker),
+		Defender: FromPlayer(rw.Defender),
+	}
+}
+
+func ToRecognitionOfWar(m *RecognitionOfWar) gamelogic.RecognitionOfWar {
+	return gamelogic.RecognitionOfWar{
+		Attacker: ToPlayer(m.GetAttacker()),
+		Defender: ToPlayer(m.GetDefender()),
+	}
+}
+
+func FromWarResult(wr gamelogic.WarResult) *WarResult {
+	return &WarResult{
+		WarId:          wr.WarID,
+		Attacker:       wr.Attacker,
+		Defender:       wr.Defender,
+		Location:       string(wr.Location),
+		Winner:         wr.Winner,
+		AttackerLosses: fromUnits(wr.AttackerLosses),
+		DefenderLosses: fromUnits(wr.DefenderLosses),
+	}
+}
+
+func ToWarResult(m *WarResult) gamelogic.WarResult {
+	return gamelogic.WarResult{
+		WarID:          m.GetWarId(),
+		Attacker:       m.GetAttacker(),
+		Defender:       m.GetDefender(),
+		Location:       gamelogic.Location(m.GetLocation()),
+		Winner:         m.GetWinner(),
+		AttackerLosses: toUnits(m.GetAttackerLosses()),
+		DefenderLosses: toUnits(m.GetDefenderLosses()),
+	}
+}
+
+func fromUnits(units []gamelogic.Unit) []*Unit {
+	out := make([]*Unit, 0, len(units))
+	for _, u := range units {
+		out = append(out, FromUnit(u))
+	}
+	return out
+}
+
+func toUnits(units []*Unit) []gamelogic.Unit {
+	out := make([]gamelogic.Unit, 0, len(units))
+	for _, u := range units {
+		out = append(out, ToUnit(u))
+	}
+	return out
+}
+
+func FromPlayingState(ps routing.PlayingState) *PlayingState {
+	return &PlayingState{IsPaused: ps.IsPaused}
+}
+
+func ToPlayingState(m *PlayingState) routing.PlayingState {
+	return routing.PlayingState{IsPaused: m.GetIsPaused()}
+}
+
+func FromGameLog(gl routing.GameLog) *GameLog {
+	return &GameLog{
+		CurrentTime: timestamppb.New(gl.CurrentTime),
+		Message:     gl.Message,
+		Username:    gl.Username,
+	}
+}
+
+func ToGameLog(m *GameLog) routing.GameLog {
+	var currentTime time.Time
+	if m.GetCurrentTime() != nil {
+		currentTime = m.GetCurrentTime().AsTime()
+	}
+	return routing.GameLog{
+		CurrentTime: currentTime,
+		Message:     m.GetMessage(),
+		Username:    m.GetUsername(),
+	}
+}
@@ -0,0 +1,110 @@
+package perilpb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRoundTrip(t *testing.T) {
+	infantry := gamelogic.Unit{ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"}
+	cavalry := gamelogic.Unit{ID: 2, Rank: gamelogic.RankCavalry, Location: "europe"}
+	alice := gamelogic.Player{Username: "alice", Units: map[int]gamelogic.Unit{1: infantry, 2: cavalry}}
+	bob := gamelogic.Player{Username: "bob", Units: map[int]gamelogic.Unit{}}
+	tests := []struct {
+		name    string
+		message any
+	}{
+		{"army move", gamelogic.ArmyMove{Player: alice, Units: []gamelogic.Unit{infantry, cavalry}, FromLocation: "europe", ToLocation: "asia"}},
+		{"recognition of war", gamelogic.RecognitionOfWar{Attacker: alice, Defender: bob}},
+		{"war result", gamelogic.WarResult{
+			WarID:          "war-1",
+			Attacker:       "alice",
+			Defender:       "bob",
+			Location:       "asia",
+			Winner:         "alice",
+			AttackerLosses: []gamelogic.Unit{infantry},
+			DefenderLosses: []gamelogic.Unit{},
+		}},
+		{"paused", routing.PlayingState{IsPaused: true}},
+		{"resumed", routing.PlayingState{IsPaused: false}},
+		{"game log", routing.GameLog{
+			CurrentTime: time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC),
+			Message:     "alice won a war",
+			Username:    "alice",
+		}},
+	}
+	for _, tt := range tests {
+		// Pointers marshal the same as the values they point to.
+		ptr := reflect.New(reflect.TypeOf(tt.message))
+		ptr.Elem().Set(reflect.ValueOf(tt.message))
+		for _, v := range []any{tt.message, ptr.Interface()} {
+			data, err := Marshal(v)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			got := reflect.New(reflect.TypeOf(tt.message))
+			if err := Unmarshal(data, got.Interface()); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if !reflect.DeepEqual(got.Elem().Interface(), tt.message) {
+				t.Errorf("%s: came back as %+v, want %+v", tt.name, got.Elem().Interface(), tt.message)
+			}
+		}
+	}
+}
+
+func TestProtoMessagesPassThrough(t *testing.T) {
+	data, err := Marshal(&Unit{Id: 7, Rank: "artillery", Location: "asia"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Unit
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Unit{Id: 7, Rank: "artillery", Location: "asia"}); !proto.Equal(&got, want) {
+		t.Errorf("got %v, want %v", &got, want)
+	}
+	// The generated message decodes into the Peril struct it stands for.
+	var move gamelogic.ArmyMove
+	moveData, err := Marshal(&ArmyMove{Units: []*Unit{&got}, ToLocation: "asia"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmarshal(moveData, &move); err != nil {
+		t.Fatal(err)
+	}
+	if want := []gamelogic.Unit{{ID: 7, Rank: gamelogic.RankArtillery, Location: "asia"}}; !reflect.DeepEqual(move.Units, want) {
+		t.Errorf("units are %+v, want %+v", move.Units, want)
+	}
+}
+
+func TestMissingFields(t *testing.T) {
+	// Messages from other languages may leave fields unset.
+	if got := ToGameLog(&GameLog{Message: "hi"}); !got.CurrentTime.IsZero() || got.Message != "hi" {
+		t.Errorf("log without a time came back as %+v", got)
+	}
+	got := ToArmyMove(&ArmyMove{ToLocation: "asia"})
+	if got.Player.Username != "" || got.Player.Units == nil || len(got.Units) != 0 {
+		t.Errorf("move without a player came back as %+v", got)
+	}
+}
+
+func TestRejectsUnknownTypes(t *testing.T) {
+	if _, err := Marshal(gamelogic.MapDefinition{}); err == nil {
+		t.Error("marshalled a type without a protobuf schema")
+	}
+	var def gamelogic.MapDefinition
+	if err := Unmarshal(nil, &def); err == nil {
+		t.Error("unmarshalled into a type without a protobuf schema")
+	}
+	var move gamelogic.ArmyMove
+	if err := Unmarshal([]byte{0xff}, &move); err == nil {
+		t.Error("unmarshalled a malformed body")
+	}
+}
@@ -0,0 +1,8 @@
+// Package perilpb holds the protobuf form of Peril's messages, generated from
+// proto/peril/v1/peril.proto, along with converters to and from the
+// gamelogic and routing structs.
+//
+// Regenerate peril.pb.go from the repository root with:
+//
+//	buf generate
+package perilpb
@@ -0,0 +1,295 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Message is one message to publish in bulk.
+type Message struct {
+	Exchange string
+	Key      routing.Key
+	Value    any
+}
+
+// BatchError reports which messages of a batch failed. Errors lines up with
+// the messages given; successful ones have a nil entry.
+type BatchError struct {
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	failed := e.Unwrap()
+	if len(failed) == 0 {
+		return "no messages failed"
+	}
+	return fmt.Sprintf("%d of %d messages failed, first: %v", len(failed), len(e.Errors), failed[0])
+}
+
+// Unwrap returns the errors of the failed messages, so errors.Is and
+// errors.As look through them.
+func (e *BatchError) Unwrap() []error {
+	var failed []error
+	for _, err := range e.Errors {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
+
+type batchItem struct {
+	ctx context.Context
+	msg Message
+}
+
+// PublishMany encodes every message with codec and publishes them all
+// before waiting for any confirm, so a batch costs one round trip rather
+// than one per message. It returns a *BatchError if any message was nacked,
+// returned as unroutable or not confirmed within the publisher's timeout.
+func PublishMany(ctx context.Context, p *ConfirmedPublisher, codec Codec, msgs []Message) error {
+	items := make([]batchItem, len(msgs))
+	for i, msg := range msgs {
+		items[i] = batchItem{ctx: ctx, msg: msg}
+	}
+	errs := p.publishBatch(ctx, codec, items)
+	for _, err := range errs {
+		if err != nil {
+			return &BatchError{Errors: errs}
+		}
+	}
+	return nil
+}
+
+// publishBatch publishes items as one pipelined batch and returns an error,
+// or nil, for each. Confirms are matched to messages by delivery tag,
+// counting from the tag the batch started at, and returns by message ID, so
+// anything left over from an earlier publish that gave up is ignored.
+func (p *ConfirmedPublisher) publishBatch(ctx context.Context, codec Codec, items []batchItem) []error {
+	errs := make([]error, len(items))
+	spans := make([]*tracing.Span, len(items))
+	defer func() {
+		for i, span := range spans {
+			if span == nil {
+				continue
+			}
+			key := items[i].msg.Key.String()
+			if errs[i] != nil {
+				span.SetError(errs[i])
+				publishFailures.With(items[i].msg.Exchange, key).Inc()
+			} else {
+				publishedTotal.With(items[i].msg.Exchange, key).Inc()
+			}
+			span.End()
+		}
+	}()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Messages the broker took get consecutive tags from first on, so sent,
+	// the indexes of those messages in publishing order, maps tags to items.
+	// A publish that fails takes no tag.
+	first := p.ch.GetNextPublishSeqNo()
+	sent := make([]int, 0, len(items))
+	ids := make([]string, len(items))
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i, item := range items {
+			exchange, key := item.msg.Exchange, item.msg.Key.String()
+			itemCtx, span := tracing.Start(item.ctx, "publish "+exchange, tracing.KindProducer,
+				"messaging.destination", exchange,
+				"messaging.routing_key", key,
+			)
+			spans[i] = span
+			data, err := codec.Marshal(item.msg.Value)
+			if err != nil {
+				errs[i] = &PublishError{Exchange: exchange, Key: key, Err: err}
+				continue
+			}
+			msg := stamp(itemCtx, amqp.Publishing{ContentType: codec.ContentType(), Body: data})
+			if err := p.ch.PublishWithContext(ctx, exchange, key, true, false, msg); err != nil {
+				errs[i] = &PublishError{Exchange: exchange, Key: key, Err: err}
+				continue
+			}
+			sent = append(sent, i)
+			ids[i] = msg.MessageId
+		}
+	}()
+
+	// Confirms and returns are taken while the batch is still going out, as
+	// a batch can be bigger than their buffers and the broker would stall
+	// waiting for room. The timeout starts once the last message is out.
+	acks := map[uint64]bool{}
+	returned := map[string]amqp.Return{}
+	confirms, returns := p.confirms, p.returns
+	publishing, done := published, ctx.Done()
+	var timeout <-chan time.Time
+	var failure error
+	for publishing != nil || (failure == nil && len(acks) < len(sent)) {
+		select {
+		case <-publishing:
+			publishing = nil
+			timer := time.NewTimer(p.timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		case r, ok := <-returns:
+			if !ok {
+				returns, failure = nil, amqp.ErrClosed
+				continue
+			}
+			returned[r.MessageId] = r
+		case c, ok := <-confirms:
+			if !ok {
+				confirms, failure = nil, amqp.ErrClosed
+				continue
+			}
+			if c.DeliveryTag >= first {
+				acks[c.DeliveryTag] = c.Ack
+			}
+		case <-timeout:
+			failure = ErrConfirmTimeout
+		case <-done:
+			done = nil
+			if failure == nil {
+				failure = ctx.Err()
+			}
+		}
+	}
+	// The broker sends basic.return before the ack of the same message, so
+	// the last returns may still be sitting in their buffer.
+	for pending := returns != nil; pending; {
+		select {
+		case r, ok := <-returns:
+			if ok {
+				returned[r.MessageId] = r
+			} else {
+				pending = false
+			}
+		default:
+			pending = false
+		}
+	}
+
+	for n, i := range sent {
+		exchange, key := items[i].msg.Exchange, items[i].msg.Key.String()
+		ack, confirmed := acks[first+uint64(n)]
+		switch r, ok := returned[ids[i]]; {
+		case !confirmed:
+			errs[i] = &PublishError{Exchange: exchange, Key: key, Err: failure}
+		case !ack:
+			errs[i] = &PublishError{Exchange: exchange, Key: key, Err: ErrPublishNacked}
+		case ok:
+			errs[i] = &PublishError{
+				Exchange:  exchange,
+				Key:       key,
+				ReplyCode: r.ReplyCode,
+				ReplyText: r.ReplyText,
+				Err:       ErrPublishUnroutable,
+			}
+		}
+	}
+	return errs
+}
+
+// BatchPublisher gathers messages published one at a time and sends them
+// through a ConfirmedPublisher in batches, once maxSize messages are waiting
+// or maxDelay after the first of them, whichever comes first.
+type BatchPublisher struct {
+	p        *ConfirmedPublisher
+	codec    Codec
+	maxSize  int
+	maxDelay time.Duration
+
+	mu      sync.Mutex
+	pending []batchItem
+	results []chan error
+	timer   *time.Timer
+	flushes sync.WaitGroup
+	closed  bool
+}
+
+var ErrBatchPublisherClosed = errors.New("batch publisher closed")
+
+// NewBatchPublisher batches messages encoded with codec onto p.
+func NewBatchPublisher(p *ConfirmedPublisher, codec Codec, maxSize int, maxDelay time.Duration) *BatchPublisher {
+	return &BatchPublisher{
+		p:        p,
+		codec:    codec,
+		maxSize:  max(maxSize, 1),
+		maxDelay: maxDelay,
+	}
+}
+
+// Publish queues a message for the next batch. The returned channel gets
+// the message's outcome once its batch is confirmed: nil, or a
+// *PublishError.
+func (b *BatchPublisher) Publish(ctx context.Context, exchange string, key routing.Key, val any) <-chan error {
+	result := make(chan error, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		result <- ErrBatchPublisherClosed
+		return result
+	}
+	b.pending = append(b.pending, batchItem{ctx: ctx, msg: Message{Exchange: exchange, Key: key, Value: val}})
+	b.results = append(b.results, result)
+	if len(b.pending) >= b.maxSize {
+		b.sendLocked()
+	} else if b.timer == nil {
+		b.timer = time.AfterFunc(b.maxDelay, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.sendLocked()
+		})
+	}
+	return result
+}
+
+// sendLocked hands the waiting messages to a goroutine that publishes them.
+func (b *BatchPublisher) sendLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	items, results := b.pending, b.results
+	b.pending, b.results = nil, nil
+	b.flushes.Add(1)
+	go func() {
+		defer b.flushes.Done()
+		errs := b.p.publishBatch(context.Background(), b.codec, items)
+		for i, result := range results {
+			result <- errs[i]
+		}
+	}()
+}
+
+// Flush sends whatever is waiting now and waits until every batch sent so
+// far has been confirmed.
+func (b *BatchPublisher) Flush() {
+	b.mu.Lock()
+	b.sendLocked()
+	b.mu.Unlock()
+	b.flushes.Wait()
+}
+
+// Close flushes and stops taking messages. It does not close the
+// underlying ConfirmedPublisher.
+func (b *BatchPublisher) Close() {
+	b.mu.Lock()
+	b.closed = true
+	b.sendLocked()
+	b.mu.Unlock()
+	b.flushes.Wait()
+}
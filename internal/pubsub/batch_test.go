@@ -0,0 +1,166 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestPublishManyBiggerThanNotifyBuffer(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	if _, _, err := DeclareAndBind(conn, routing.ExchangePerilTopic, routing.WarQueue("alice"), routing.WarKey("alice").Pattern(), Durable); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewConfirmedPublisher(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// Every third message goes nowhere.
+	msgs := make([]Message, 3*notifyBuffer)
+	for i := range msgs {
+		key := routing.WarKey("alice")
+		if i%3 == 2 {
+			key = routing.WarKey("bob")
+		}
+		msgs[i] = Message{Exchange: routing.ExchangePerilTopic, Key: key, Value: i}
+	}
+	err = PublishMany(context.Background(), p, JSON, msgs)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("got %v, want a *BatchError", err)
+	}
+	for i, err := range batchErr.Errors {
+		if unroutable := errors.Is(err, ErrPublishUnroutable); unroutable != (i%3 == 2) {
+			t.Errorf("message %d: got %v", i, err)
+		}
+	}
+	if n := queueLength(t, ch, routing.WarQueue("alice")); n != 2*notifyBuffer {
+		t.Errorf("%d messages routed, want %d", n, 2*notifyBuffer)
+	}
+}
+
+func TestPublishManySkipsLateConfirms(t *testing.T) {
+	ch := &scriptedChannel{}
+	p, err := NewConfirmedPublisher(scriptedTransport{ch: ch}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.PublishWithContext(context.Background(), "ex", "key", false, false, amqp.Publishing{})
+	if !errors.Is(err, ErrConfirmTimeout) {
+		t.Fatalf("single publish: got %v, want a timeout", err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- PublishMany(context.Background(), p, JSON, []Message{
+			{Exchange: "ex", Key: routing.WarKey("a"), Value: 1},
+			{Exchange: "ex", Key: routing.WarKey("b"), Value: 2},
+		})
+	}()
+	late, first, second := ch.message(t, 1), ch.message(t, 2), ch.message(t, 3)
+	ch.returns <- amqp.Return{MessageId: late.MessageId, ReplyCode: amqp.NoRoute}
+	ch.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+	ch.confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+	ch.returns <- amqp.Return{MessageId: second.MessageId, ReplyCode: amqp.NoRoute}
+	ch.confirms <- amqp.Confirmation{DeliveryTag: 3, Ack: true}
+
+	var batchErr *BatchError
+	if err := <-result; !errors.As(err, &batchErr) {
+		t.Fatalf("got %v, want a *BatchError", err)
+	}
+	if err := batchErr.Errors[0]; err != nil {
+		t.Errorf("message %s: got %v, want it confirmed", first.MessageId, err)
+	}
+	if err := batchErr.Errors[1]; !errors.Is(err, ErrPublishUnroutable) {
+		t.Errorf("message %s: got %v, want unroutable", second.MessageId, err)
+	}
+}
+
+func waitResult(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(2 * time.Second):
+		t.Fatal("no outcome for the message")
+		return nil
+	}
+}
+
+func TestBatchPublisherFlushesOnSize(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	if _, _, err := DeclareAndBind(conn, routing.ExchangePerilTopic, routing.WarQueue("alice"), routing.WarKey("alice").Pattern(), Durable); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewConfirmedPublisher(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	b := NewBatchPublisher(p, JSON, 3, time.Hour)
+
+	var results []<-chan error
+	for i := 0; i < 3; i++ {
+		results = append(results, b.Publish(context.Background(), routing.ExchangePerilTopic, routing.WarKey("alice"), i))
+	}
+	for i, result := range results {
+		if err := waitResult(t, result); err != nil {
+			t.Errorf("message %d: %v", i, err)
+		}
+	}
+	if n := queueLength(t, ch, routing.WarQueue("alice")); n != 3 {
+		t.Errorf("%d messages routed, want the full batch of 3", n)
+	}
+
+	// One message on its own waits for the hour, or for Close.
+	last := b.Publish(context.Background(), routing.ExchangePerilTopic, routing.WarKey("alice"), 3)
+	select {
+	case err := <-last:
+		t.Fatalf("sent a batch of one early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	b.Close()
+	if err := waitResult(t, last); err != nil {
+		t.Errorf("flushed on close: %v", err)
+	}
+	if err := waitResult(t, b.Publish(context.Background(), routing.ExchangePerilTopic, routing.WarKey("alice"), 4)); !errors.Is(err, ErrBatchPublisherClosed) {
+		t.Errorf("published after close: got %v", err)
+	}
+}
+
+func TestBatchPublisherFlushesOnInterval(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	if _, _, err := DeclareAndBind(conn, routing.ExchangePerilTopic, routing.WarQueue("alice"), routing.WarKey("alice").Pattern(), Durable); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewConfirmedPublisher(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	const delay = 20 * time.Millisecond
+	b := NewBatchPublisher(p, JSON, 100, delay)
+	defer b.Close()
+
+	start := time.Now()
+	routed := b.Publish(context.Background(), routing.ExchangePerilTopic, routing.WarKey("alice"), 1)
+	lost := b.Publish(context.Background(), routing.ExchangePerilTopic, routing.WarKey("bob"), 2)
+	if err := waitResult(t, routed); err != nil {
+		t.Errorf("routed message: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("batch sent after %v, want it to wait %v for more", elapsed, delay)
+	}
+	if err := waitResult(t, lost); !errors.Is(err, ErrPublishUnroutable) {
+		t.Errorf("message to bob: got %v, want unroutable", err)
+	}
+	if n := queueLength(t, ch, routing.WarQueue("alice")); n != 1 {
+		t.Errorf("%d messages routed, want 1", n)
+	}
+}
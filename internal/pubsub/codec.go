@@ -0,0 +1,177 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/perilpb"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeGob      = "application/gob"
+	ContentTypeMsgPack  = "application/msgpack"
+	ContentTypeCBOR     = "application/cbor"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Codec turns values into message bodies and back for one content type.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSON     Codec = jsonCodec{}
+	Gob      Codec = gobCodec{}
+	MsgPack  Codec = msgpackCodec{}
+	CBOR     Codec = cborCodec{}
+	Protobuf Codec = protobufCodec{}
+)
+
+var registry = struct {
+	sync.RWMutex
+	codecs map[string]Codec
+}{codecs: map[string]Codec{}}
+
+func init() {
+	for _, c := range []Codec{JSON, Gob, MsgPack, CBOR, Protobuf} {
+		RegisterCodec(c)
+	}
+}
+
+// RegisterCodec makes c available to subscribers for its content type,
+// replacing any codec already registered for it.
+func RegisterCodec(c Codec) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.codecs[c.ContentType()] = c
+}
+
+// CodecFor looks up the codec for a content type. Parameters such as
+// charset are ignored.
+func CodecFor(contentType string) (Codec, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+	registry.RLock()
+	defer registry.RUnlock()
+	c, ok := registry.codecs[mediaType]
+	if !ok {
+		return nil, fmt.Errorf("no codec registered for content type %q", mediaType)
+	}
+	return c, nil
+}
+
+// decoderFor returns an unmarshaller that picks the codec from the delivery's
+// content type, using fallback for deliveries that don't set one.
+func decoderFor[T any](fallback Codec) func(contentType string, body []byte) (T, error) {
+	return func(contentType string, body []byte) (T, error) {
+		var message T
+		codec := fallback
+		if contentType != "" {
+			c, err := CodecFor(contentType)
+			if err != nil {
+				return message, err
+			}
+			codec = c
+		}
+		if err := codec.Unmarshal(body, &message); err != nil {
+			return message, err
+		}
+		return message, nil
+	}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string {
+	return ContentTypeGob
+}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return ContentTypeMsgPack
+}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// cborEncoding keeps nanoseconds in timestamps; the default drops them.
+var cborEncoding = func() cbor.EncMode {
+	mode, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}()
+
+type cborCodec struct{}
+
+func (cborCodec) ContentType() string {
+	return ContentTypeCBOR
+}
+
+func (cborCodec) Marshal(v any) ([]byte, error) {
+	return cborEncoding.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v any) error {
+	return cbor.Unmarshal(data, v)
+}
+
+// protobufCodec speaks the schemas in proto/peril/v1. It accepts the Peril
+// message structs directly and converts them through perilpb.
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	return perilpb.Marshal(v)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	return perilpb.Unmarshal(data, v)
+}
@@ -0,0 +1,135 @@
+package pubsub
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// codecs are the built-in codecs every Peril message round-trips through.
+var codecs = []Codec{JSON, Gob, MsgPack, CBOR, Protobuf}
+
+func TestCodecRoundTrip(t *testing.T) {
+	move := gamelogic.ArmyMove{
+		Player: gamelogic.Player{Username: "alice", Units: map[int]gamelogic.Unit{
+			1: {ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"},
+		}},
+		Units:        []gamelogic.Unit{{ID: 1, Rank: gamelogic.RankInfantry, Location: "europe"}},
+		FromLocation: "europe",
+		ToLocation:   "asia",
+	}
+	log := routing.GameLog{
+		CurrentTime: time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC),
+		Message:     "alice won a war",
+		Username:    "alice",
+	}
+	for _, codec := range codecs {
+		data, err := codec.Marshal(move)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		var gotMove gamelogic.ArmyMove
+		if err := codec.Unmarshal(data, &gotMove); err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		if !reflect.DeepEqual(gotMove, move) {
+			t.Errorf("%s: move came back as %+v, want %+v", codec.ContentType(), gotMove, move)
+		}
+
+		if data, err = codec.Marshal(log); err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		var gotLog routing.GameLog
+		if err := codec.Unmarshal(data, &gotLog); err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		// Codecs may hand the time back in another location, so it is
+		// compared as an instant.
+		if !gotLog.CurrentTime.Equal(log.CurrentTime) || gotLog.Message != log.Message || gotLog.Username != log.Username {
+			t.Errorf("%s: log came back as %+v, want %+v", codec.ContentType(), gotLog, log)
+		}
+	}
+}
+
+func TestProtobufRejectsUnknownTypes(t *testing.T) {
+	if _, err := Protobuf.Marshal(map[string]int{"a": 1}); err == nil {
+		t.Error("marshalled a type without a protobuf schema")
+	}
+	var v struct{ A int }
+	if err := Protobuf.Unmarshal(nil, &v); err == nil {
+		t.Error("unmarshalled into a type without a protobuf schema")
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        Codec
+	}{
+		{"application/json", JSON},
+		{"application/json; charset=utf-8", JSON},
+		{"Application/JSON", JSON},
+		{"application/gob", Gob},
+		{"application/msgpack", MsgPack},
+		{"application/cbor", CBOR},
+		{"application/x-protobuf", Protobuf},
+		{"text/plain", nil},
+		{"", nil},
+		{"application/json; charset", nil},
+	}
+	for _, tt := range tests {
+		got, err := CodecFor(tt.contentType)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("CodecFor(%q) = %s, want an error", tt.contentType, got.ContentType())
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("CodecFor(%q) = %v, %v, want %s", tt.contentType, got, err, tt.want.ContentType())
+		}
+	}
+}
+
+func TestSubscribeDecodesByContentType(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	key := routing.ArmyMovesKey("codec")
+	messages := make(chan string, len(codecs)+1)
+	sub, err := Subscribe(context.Background(), conn, routing.ExchangePerilTopic, "codec_test", key.Pattern(), Transient,
+		func(gl routing.GameLog) ActType {
+			messages <- gl.Message
+			return Ack
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Stop(context.Background()) })
+
+	want := map[string]bool{}
+	for _, codec := range codecs {
+		want[codec.ContentType()] = true
+		if err := Publish(context.Background(), ch, codec, routing.ExchangePerilTopic, key, routing.GameLog{Message: codec.ContentType()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Deliveries without a content type are read as JSON.
+	want["untyped"] = true
+	publishBody(t, ch, routing.ExchangePerilTopic, key.String(), `{"Message": "untyped"}`)
+
+	for len(want) > 0 {
+		select {
+		case msg := <-messages:
+			if !want[msg] {
+				t.Errorf("got unexpected message %q", msg)
+			}
+			delete(want, msg)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("still waiting for %v", want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	ErrPublishNacked     = errors.New("broker nacked the message")
+	ErrPublishUnroutable = errors.New("message was returned as unroutable")
+	ErrConfirmTimeout    = errors.New("timed out waiting for publisher confirm")
+)
+
+// PublishError is returned by a ConfirmedPublisher when a message was not
+// safely accepted by the broker. Err is one of the ErrPublish* values, a
+// timeout, or the error from the channel itself.
+type PublishError struct {
+	Exchange  string
+	Key       string
+	ReplyCode uint16 // set when the message was returned
+	ReplyText string
+	Err       error
+}
+
+func (e *PublishError) Error() string {
+	if e.ReplyText != "" {
+		return fmt.Sprintf("publish to %s with key %s: %v (%d %s)", e.Exchange, e.Key, e.Err, e.ReplyCode, e.ReplyText)
+	}
+	return fmt.Sprintf("publish to %s with key %s: %v", e.Exchange, e.Key, e.Err)
+}
+
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
+
+// ConfirmedPublisher publishes on its own channel in confirm mode. Every
+// message is sent as mandatory and each publish waits for the broker's ack,
+// so a nack, a timeout or an unroutable message surfaces as a *PublishError
+// instead of vanishing. Confirms are matched to publishes by delivery tag and
+// returns by message ID, so the answer to a publish that gave up waiting is
+// never mistaken for the answer to the next one.
+type ConfirmedPublisher struct {
+	mu       sync.Mutex
+	ch       Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	timeout  time.Duration
+}
+
+// NewConfirmedPublisher opens a channel on conn and puts it in confirm mode.
+// timeout bounds how long each publish waits for its confirm.
+func NewConfirmedPublisher(conn Transport, timeout time.Duration) (*ConfirmedPublisher, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	return &ConfirmedPublisher{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, notifyBuffer)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, notifyBuffer)),
+		timeout:  timeout,
+	}, nil
+}
+
+// PublishWithContext publishes msg and waits for the broker to confirm it.
+// The message is always published as mandatory, whatever mandatory says.
+// Confirms and returns left over from publishes that timed out are skipped
+// as they turn up.
+func (p *ConfirmedPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if msg.MessageId == "" {
+		msg.MessageId = newMessageID()
+	}
+	tag := p.ch.GetNextPublishSeqNo()
+	if err := p.ch.PublishWithContext(ctx, exchange, key, true, immediate, msg); err != nil {
+		return &PublishError{Exchange: exchange, Key: key, Err: err}
+	}
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	var returned *amqp.Return
+	takeReturn := func(r amqp.Return) {
+		if r.MessageId == msg.MessageId {
+			returned = &r
+		}
+	}
+	for {
+		select {
+		case r, ok := <-p.returns:
+			if !ok {
+				return &PublishError{Exchange: exchange, Key: key, Err: amqp.ErrClosed}
+			}
+			takeReturn(r)
+		case c, ok := <-p.confirms:
+			if !ok {
+				return &PublishError{Exchange: exchange, Key: key, Err: amqp.ErrClosed}
+			}
+			// The broker sends basic.return before the ack of the same
+			// message, so a return may still be sitting in its buffer.
+			for pending := true; pending; {
+				select {
+				case r, ok := <-p.returns:
+					if ok {
+						takeReturn(r)
+					} else {
+						pending = false
+					}
+				default:
+					pending = false
+				}
+			}
+			if c.DeliveryTag < tag {
+				// An earlier publish's confirm. Any return taken so far was
+				// that publish's too, since ours can only come after it.
+				returned = nil
+				continue
+			}
+			if c.DeliveryTag > tag {
+				// Confirms come in order, so ours is never coming: the
+				// channel it went out on was lost.
+				return &PublishError{Exchange: exchange, Key: key, Err: amqp.ErrClosed}
+			}
+			if !c.Ack {
+				return &PublishError{Exchange: exchange, Key: key, Err: ErrPublishNacked}
+			}
+			if returned != nil {
+				return &PublishError{
+					Exchange:  exchange,
+					Key:       key,
+					ReplyCode: returned.ReplyCode,
+					ReplyText: returned.ReplyText,
+					Err:       ErrPublishUnroutable,
+				}
+			}
+			return nil
+		case <-timer.C:
+			return &PublishError{Exchange: exchange, Key: key, Err: ErrConfirmTimeout}
+		case <-ctx.Done():
+			return &PublishError{Exchange: exchange, Key: key, Err: ctx.Err()}
+		}
+	}
+}
+
+func (p *ConfirmedPublisher) Close() error {
+	return p.ch.Close()
+}
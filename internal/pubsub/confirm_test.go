@@ -0,0 +1,170 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// scriptedChannel is a channel in confirm mode whose confirms and returns
+// the test sends by hand.
+type scriptedChannel struct {
+	Channel
+
+	mu        sync.Mutex
+	published []amqp.Publishing
+	confirms  chan amqp.Confirmation
+	returns   chan amqp.Return
+}
+
+// scriptedTransport hands out its one channel.
+type scriptedTransport struct {
+	Transport
+	ch Channel
+}
+
+func (t scriptedTransport) Channel() (Channel, error) { return t.ch, nil }
+
+func (ch *scriptedChannel) Confirm(noWait bool) error                       { return nil }
+func (ch *scriptedChannel) Close() error                                    { return nil }
+func (ch *scriptedChannel) NotifyClose(c chan *amqp.Error) chan *amqp.Error { return c }
+func (ch *scriptedChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	ch.returns = c
+	return c
+}
+func (ch *scriptedChannel) NotifyPublish(c chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.confirms = c
+	return c
+}
+
+func (ch *scriptedChannel) GetNextPublishSeqNo() uint64 {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return uint64(len(ch.published)) + 1
+}
+
+func (ch *scriptedChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	ch.mu.Lock()
+	ch.published = append(ch.published, msg)
+	ch.mu.Unlock()
+	return nil
+}
+
+// message waits for the nth publish, counting from 1.
+func (ch *scriptedChannel) message(t *testing.T, n int) amqp.Publishing {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		ch.mu.Lock()
+		if len(ch.published) >= n {
+			defer ch.mu.Unlock()
+			return ch.published[n-1]
+		}
+		ch.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("publish %d never happened", n)
+	return amqp.Publishing{}
+}
+
+func TestConfirmedPublisherSkipsLateConfirms(t *testing.T) {
+	ch := &scriptedChannel{}
+	p, err := NewConfirmedPublisher(scriptedTransport{ch: ch}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish := func() <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			result <- p.PublishWithContext(context.Background(), "ex", "key", false, false, amqp.Publishing{})
+		}()
+		return result
+	}
+
+	first := publish()
+	ch.message(t, 1)
+	if err := <-first; !errors.Is(err, ErrConfirmTimeout) {
+		t.Fatalf("first publish: got %v, want a timeout", err)
+	}
+
+	// The first message's return and nack turn up while the second waits.
+	second := publish()
+	msg1, msg2 := ch.message(t, 1), ch.message(t, 2)
+	ch.returns <- amqp.Return{MessageId: msg1.MessageId, ReplyCode: amqp.NoRoute}
+	ch.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+	ch.confirms <- amqp.Confirmation{DeliveryTag: 2, Ack: true}
+	if err := <-second; err != nil {
+		t.Fatalf("second publish: got %v, want it confirmed", err)
+	}
+
+	third := publish()
+	msg3 := ch.message(t, 3)
+	ch.returns <- amqp.Return{MessageId: msg2.MessageId, ReplyCode: amqp.NoRoute}
+	ch.returns <- amqp.Return{MessageId: msg3.MessageId, ReplyCode: amqp.NoRoute, ReplyText: "NO_ROUTE"}
+	ch.confirms <- amqp.Confirmation{DeliveryTag: 3, Ack: true}
+	if err := <-third; !errors.Is(err, ErrPublishUnroutable) {
+		t.Fatalf("third publish: got %v, want unroutable", err)
+	}
+}
+
+func TestConfirmedPublisherOnMemoryBroker(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	if _, _, err := DeclareAndBind(conn, routing.ExchangePerilTopic, routing.WarQueue("alice"), routing.WarKey("alice").Pattern(), Durable); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewConfirmedPublisher(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if err := PublishJSON(p, routing.ExchangePerilTopic, routing.WarKey("alice"), "war"); err != nil {
+		t.Errorf("routed publish: got %v, want it confirmed", err)
+	}
+	err = PublishJSON(p, routing.ExchangePerilTopic, routing.WarKey("bob"), "war")
+	var pubErr *PublishError
+	if !errors.As(err, &pubErr) || !errors.Is(err, ErrPublishUnroutable) || pubErr.ReplyCode != amqp.NoRoute {
+		t.Errorf("unroutable publish: got %v, want a NO_ROUTE return", err)
+	}
+	var got string
+	waitGet(t, ch, routing.WarQueue("alice"), &got)
+}
+
+func TestConfirmedPublisherAcrossReconnect(t *testing.T) {
+	b := NewMemoryBroker()
+	conn, err := NewManagedConnection(func() (Transport, error) { return b.Connect(), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p, err := NewConfirmedPublisher(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	publish := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		return p.PublishWithContext(ctx, "", "nowhere", false, false, amqp.Publishing{})
+	}
+	for i := 0; i < 2; i++ {
+		if err := publish(); !errors.Is(err, ErrPublishUnroutable) {
+			t.Fatalf("publish %d: got %v, want unroutable", i+1, err)
+		}
+	}
+	b.DropConnections()
+	// Tags carry on from the lost channel rather than starting again at 1,
+	// so the first confirm on the new one still matches.
+	if err := publish(); !errors.Is(err, ErrPublishUnroutable) {
+		t.Fatalf("publish after reconnect: got %v, want unroutable", err)
+	}
+	if next := p.ch.GetNextPublishSeqNo(); next != 4 {
+		t.Errorf("next delivery tag is %d, want 4", next)
+	}
+}
@@ -0,0 +1,95 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DeclareDeadLetter declares the peril_dlx fanout exchange that every Peril
+// queue dead-letters to, and the durable peril_dlq queue that collects what
+// it receives. It is safe to call any number of times.
+func DeclareDeadLetter(ch Channel) error {
+	if err := ch.ExchangeDeclare(routing.ExchangePerilDLX, routing.KindFanout, true, false, false, false, nil); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(routing.QueuePerilDLQ, true, false, false, false, nil); err != nil {
+		return err
+	}
+	return ch.QueueBind(routing.QueuePerilDLQ, "", routing.ExchangePerilDLX, false, nil)
+}
+
+// Death is one entry of a dead-lettered message's x-death header.
+type Death struct {
+	Reason      string
+	Queue       string
+	Exchange    string
+	RoutingKeys []string
+	Count       int
+	Time        time.Time
+}
+
+// Deaths reads the x-death header, most recent first.
+func Deaths(headers amqp.Table) []Death {
+	entries, _ := headers["x-death"].([]interface{})
+	deaths := make([]Death, 0, len(entries))
+	for _, entry := range entries {
+		table, ok := entry.(amqp.Table)
+		if !ok {
+			continue
+		}
+		var death Death
+		death.Reason, _ = table["reason"].(string)
+		death.Queue, _ = table["queue"].(string)
+		death.Exchange, _ = table["exchange"].(string)
+		death.Count, _ = headerInt(table["count"])
+		death.Time, _ = table["time"].(time.Time)
+		keys, _ := table["routing-keys"].([]interface{})
+		for _, key := range keys {
+			if k, ok := key.(string); ok {
+				death.RoutingKeys = append(death.RoutingKeys, k)
+			}
+		}
+		deaths = append(deaths, death)
+	}
+	return deaths
+}
+
+// Origin is the exchange and routing key a dead-lettered message was first
+// published to, looking through any retries it went through on the way.
+func Origin(d amqp.Delivery) (exchange, key string) {
+	if exchange, ok := d.Headers[HeaderOriginalExchange].(string); ok {
+		key, _ := d.Headers[HeaderOriginalRoutingKey].(string)
+		return exchange, key
+	}
+	deaths := Deaths(d.Headers)
+	if len(deaths) == 0 {
+		return d.Exchange, d.RoutingKey
+	}
+	first := deaths[len(deaths)-1]
+	if len(first.RoutingKeys) > 0 {
+		key = first.RoutingKeys[0]
+	}
+	return first.Exchange, key
+}
+
+// Replay publishes a dead-lettered message again to where it was first sent.
+// Its retry count starts over; the x-death history is kept. Publish through a
+// ConfirmedPublisher and only settle d once Replay returns nil, or a publish
+// the broker drops loses the message for good.
+func Replay(ctx context.Context, ch Publisher, d amqp.Delivery) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	delete(headers, HeaderAttempts)
+	delete(headers, HeaderOriginalExchange)
+	delete(headers, HeaderOriginalRoutingKey)
+
+	exchange, key := Origin(d)
+	msg := publishingFrom(d)
+	msg.Headers = headers
+	return ch.PublishWithContext(ctx, exchange, key, false, false, msg)
+}
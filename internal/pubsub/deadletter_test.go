@@ -0,0 +1,76 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestReplayPublishesToOrigin(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	moves, _, err := DeclareAndBind(conn, routing.ExchangePerilTopic, "moves", routing.ArmyMovesPattern, Durable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishMove(t, ch, routing.ArmyMovesKey("alice"), moveOf("alice", "europe", "asia", gamelogic.Unit{ID: 1, Rank: "infantry"}))
+	d, ok, err := moves.Get("moves", false)
+	if err != nil || !ok {
+		t.Fatalf("move never arrived: %v", err)
+	}
+	d.Nack(false, false)
+
+	dead, ok, err := ch.Get(routing.QueuePerilDLQ, false)
+	if err != nil || !ok {
+		t.Fatalf("move was not dead-lettered: %v", err)
+	}
+	p, err := NewConfirmedPublisher(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := Replay(context.Background(), p, dead); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	dead.Ack(false)
+
+	var mv gamelogic.ArmyMove
+	env := waitGet(t, moves, "moves", &mv)
+	if env.RoutingKey != routing.ArmyMovesKey("alice").String() || mv.Player.Username != "alice" {
+		t.Errorf("replayed %+v with key %s, want alice's move on her key", mv, env.RoutingKey)
+	}
+}
+
+func TestReplayReportsUnroutable(t *testing.T) {
+	b, conn, ch := perilBroker(t)
+	player := b.Connect()
+	moves, _, err := DeclareAndBind(player, routing.ExchangePerilTopic, "moves", routing.ArmyMovesPattern, Transient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publishMove(t, ch, routing.ArmyMovesKey("alice"), moveOf("alice", "europe", "asia", gamelogic.Unit{ID: 1, Rank: "infantry"}))
+	d, ok, err := moves.Get("moves", false)
+	if err != nil || !ok {
+		t.Fatalf("move never arrived: %v", err)
+	}
+	d.Nack(false, false)
+	// The queue goes with its player's connection, leaving nowhere to
+	// replay to.
+	player.Close()
+
+	dead, ok, err := ch.Get(routing.QueuePerilDLQ, false)
+	if err != nil || !ok {
+		t.Fatalf("move was not dead-lettered: %v", err)
+	}
+	p, err := NewConfirmedPublisher(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := Replay(context.Background(), p, dead); !errors.Is(err, ErrPublishUnroutable) {
+		t.Fatalf("replay: got %v, want unroutable", err)
+	}
+}
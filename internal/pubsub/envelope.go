@@ -0,0 +1,182 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	HeaderSender        = "x-peril-sender"
+	HeaderSchemaVersion = "x-peril-schema-version"
+	HeaderTraceparent   = "traceparent"
+)
+
+// SchemaVersion is stamped on every message this build publishes. Bump it
+// when a message struct changes shape.
+const SchemaVersion = 1
+
+// AppID identifies the publishing program. It defaults to the binary name.
+var AppID = filepath.Base(os.Args[0])
+
+// Envelope is the metadata that travels with every Peril message. Attempt
+// counts deliveries under a RetryPolicy, starting at 1, and Exchange and
+// RoutingKey are where the message was first published even after retries.
+// Queue is the queue it was consumed from.
+type Envelope struct {
+	MessageID     string
+	CorrelationID string
+	ReplyTo       string
+	Timestamp     time.Time
+	AppID         string
+	Sender        string
+	SchemaVersion int
+	ContentType   string
+	Exchange      string
+	RoutingKey    string
+	Queue         string
+	Redelivered   bool
+	Attempt       int
+	Headers       amqp.Table
+
+	ctx context.Context
+}
+
+// Context carries the span the message is being handled in. Publish with it,
+// or a context derived from it, to continue the trace.
+func (e Envelope) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
+}
+
+type senderKey struct{}
+
+type correlationKey struct{}
+
+// ContextWithSender marks messages published with ctx as sent by username.
+func ContextWithSender(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, senderKey{}, username)
+}
+
+// ContextWithCorrelationID makes messages published with ctx carry id as
+// their correlation ID. Without one, a message starts a new chain and its
+// correlation ID is its own message ID.
+func ContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// Correlate returns ctx set up so that anything published with it continues
+// the chain e belongs to.
+func (e Envelope) Correlate(ctx context.Context) context.Context {
+	return ContextWithCorrelationID(ctx, e.CorrelationID)
+}
+
+// stamp fills in the envelope fields of msg from ctx.
+func stamp(ctx context.Context, msg amqp.Publishing) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	if sender, ok := ctx.Value(senderKey{}).(string); ok {
+		headers[HeaderSender] = sender
+	}
+	if traceparent := tracing.Traceparent(ctx); traceparent != "" {
+		headers[HeaderTraceparent] = traceparent
+	}
+	headers[HeaderSchemaVersion] = int32(SchemaVersion)
+	msg.Headers = headers
+
+	if msg.MessageId == "" {
+		msg.MessageId = newMessageID()
+	}
+	if msg.CorrelationId == "" {
+		if id, ok := ctx.Value(correlationKey{}).(string); ok && id != "" {
+			msg.CorrelationId = id
+		} else {
+			msg.CorrelationId = msg.MessageId
+		}
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	if msg.AppId == "" {
+		msg.AppId = AppID
+	}
+	return msg
+}
+
+func envelopeFrom(d amqp.Delivery) Envelope {
+	env := Envelope{
+		MessageID:     d.MessageId,
+		CorrelationID: d.CorrelationId,
+		ReplyTo:       d.ReplyTo,
+		Timestamp:     d.Timestamp,
+		AppID:         d.AppId,
+		ContentType:   d.ContentType,
+		Exchange:      d.Exchange,
+		RoutingKey:    d.RoutingKey,
+		Redelivered:   d.Redelivered,
+		Headers:       d.Headers,
+	}
+	env.Sender, _ = d.Headers[HeaderSender].(string)
+	env.SchemaVersion, _ = headerInt(d.Headers[HeaderSchemaVersion])
+	env.Attempt = deliveryAttempt(d.Headers)
+	if exchange, ok := d.Headers[HeaderOriginalExchange].(string); ok {
+		env.Exchange = exchange
+		env.RoutingKey, _ = d.Headers[HeaderOriginalRoutingKey].(string)
+	}
+	return env
+}
+
+// headerInt reads an integer header whatever width the broker decoded it as.
+func headerInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int8:
+		return int(n), true
+	case int16:
+		return int(n), true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case uint8:
+		return int(n), true
+	case uint16:
+		return int(n), true
+	case uint32:
+		return int(n), true
+	default:
+		return 0, false
+	}
+}
+
+// newMessageID returns a random version 4 UUID.
+func newMessageID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// remoteSpan reads the trace context a message was published with.
+func remoteSpan(headers amqp.Table) (tracing.SpanContext, bool) {
+	traceparent, ok := headers[HeaderTraceparent].(string)
+	if !ok {
+		return tracing.SpanContext{}, false
+	}
+	sc, err := tracing.ParseTraceparent(traceparent)
+	return sc, err == nil
+}
@@ -0,0 +1,131 @@
+package pubsub
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// subscribeEnvelopes returns the envelope of every GameLog published under
+// key.
+func subscribeEnvelopes(t *testing.T, conn Transport, key routing.Key) <-chan Envelope {
+	t.Helper()
+	envelopes := make(chan Envelope, 10)
+	sub, err := SubscribeEnvelope(context.Background(), conn, routing.ExchangePerilTopic, "envelope_test", key.Pattern(), Transient,
+		func(_ routing.GameLog, env Envelope) ActType {
+			envelopes <- env
+			return Ack
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Stop(context.Background()) })
+	return envelopes
+}
+
+var uuidV4 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	key := routing.ArmyMovesKey("envelope")
+	envelopes := subscribeEnvelopes(t, conn, key)
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		codec         Codec
+		sender        string
+		correlationID string
+	}{
+		{"sender and correlation ID", ContextWithCorrelationID(ContextWithSender(context.Background(), "alice"), "chain-1"), JSON, "alice", "chain-1"},
+		{"no sender", context.Background(), Gob, "", ""},
+		{"escaped sender", ContextWithSender(context.Background(), "a.*.#"), CBOR, "a.*.#", ""},
+	}
+	for _, tt := range tests {
+		before := time.Now().Truncate(time.Second)
+		if err := Publish(tt.ctx, ch, tt.codec, routing.ExchangePerilTopic, key, routing.GameLog{Message: tt.name}); err != nil {
+			t.Fatal(err)
+		}
+		env := nextEnvelope(t, envelopes)
+		if !uuidV4.MatchString(env.MessageID) {
+			t.Errorf("%s: message ID %q is not a UUID", tt.name, env.MessageID)
+		}
+		// A message that doesn't continue a chain starts its own.
+		wantCorrelation := tt.correlationID
+		if wantCorrelation == "" {
+			wantCorrelation = env.MessageID
+		}
+		if env.CorrelationID != wantCorrelation {
+			t.Errorf("%s: correlation ID %q, want %q", tt.name, env.CorrelationID, wantCorrelation)
+		}
+		if env.Sender != tt.sender {
+			t.Errorf("%s: sender %q, want %q", tt.name, env.Sender, tt.sender)
+		}
+		if env.SchemaVersion != SchemaVersion {
+			t.Errorf("%s: schema version %d, want %d", tt.name, env.SchemaVersion, SchemaVersion)
+		}
+		if env.AppID != AppID || env.ContentType != tt.codec.ContentType() {
+			t.Errorf("%s: app %q and content type %q, want %q and %q", tt.name, env.AppID, env.ContentType, AppID, tt.codec.ContentType())
+		}
+		if env.Timestamp.Before(before) || env.Timestamp.After(time.Now()) {
+			t.Errorf("%s: timestamp %v is not when it was published", tt.name, env.Timestamp)
+		}
+		if env.Exchange != routing.ExchangePerilTopic || env.RoutingKey != key.String() || env.Queue != "envelope_test" || env.Attempt != 1 {
+			t.Errorf("%s: delivered as %+v", tt.name, env)
+		}
+	}
+}
+
+func TestEnvelopeCorrelateContinuesTheChain(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	key := routing.ArmyMovesKey("envelope")
+	envelopes := subscribeEnvelopes(t, conn, key)
+
+	if err := Publish(context.Background(), ch, JSON, routing.ExchangePerilTopic, key, routing.GameLog{}); err != nil {
+		t.Fatal(err)
+	}
+	first := nextEnvelope(t, envelopes)
+	if err := Publish(first.Correlate(context.Background()), ch, JSON, routing.ExchangePerilTopic, key, routing.GameLog{}); err != nil {
+		t.Fatal(err)
+	}
+	reply := nextEnvelope(t, envelopes)
+	if reply.CorrelationID != first.MessageID || reply.MessageID == first.MessageID {
+		t.Errorf("reply %s in chain %s, want a new message in chain %s", reply.MessageID, reply.CorrelationID, first.MessageID)
+	}
+}
+
+func TestStampKeepsWhatIsSet(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	msg := stamp(ContextWithSender(context.Background(), "alice"), amqp.Publishing{
+		MessageId: "fixed",
+		Timestamp: at,
+		AppId:     "replayer",
+		Headers:   amqp.Table{"x-custom": "kept"},
+	})
+	if msg.MessageId != "fixed" || msg.CorrelationId != "fixed" || !msg.Timestamp.Equal(at) || msg.AppId != "replayer" {
+		t.Errorf("stamped as %+v", msg)
+	}
+	if msg.Headers["x-custom"] != "kept" || msg.Headers[HeaderSender] != "alice" {
+		t.Errorf("headers are %v", msg.Headers)
+	}
+}
+
+func TestHeaderInt(t *testing.T) {
+	// Brokers and clients decode the schema version at whatever width they
+	// like.
+	for _, v := range []any{int(3), int8(3), int16(3), int32(3), int64(3), uint8(3), uint16(3), uint32(3)} {
+		if n, ok := headerInt(v); !ok || n != 3 {
+			t.Errorf("headerInt(%T) = %d, %v, want 3", v, n, ok)
+		}
+	}
+	for _, v := range []any{nil, "3", 3.0} {
+		if n, ok := headerInt(v); ok {
+			t.Errorf("headerInt(%#v) = %d, want no integer", v, n)
+		}
+	}
+}
@@ -0,0 +1,647 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	reconnectMinDelay = 500 * time.Millisecond
+	reconnectMaxDelay = 30 * time.Second
+
+	// notifyBuffer bounds the confirms and returns buffered per channel.
+	notifyBuffer = 128
+)
+
+// ManagedConnection is a Transport that survives broker restarts. When the
+// underlying connection closes it redials with exponential backoff, and every
+// channel it handed out reopens itself, re-declares the exchanges, queues and
+// bindings declared through it and re-establishes its consumers. Publishing on
+// a managed channel blocks while the connection is down.
+type ManagedConnection struct {
+	dial func() (Transport, error)
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	current  Transport
+	channels map[*managedChannel]struct{}
+	closes   []chan *amqp.Error
+	closed   bool
+}
+
+// DialManaged connects to RabbitMQ at url and keeps the connection alive.
+func DialManaged(url string) (*ManagedConnection, error) {
+	return NewManagedConnection(func() (Transport, error) {
+		return Dial(url)
+	})
+}
+
+// NewManagedConnection dials once and then keeps redialing through dial
+// whenever the connection is lost. The first dial must succeed.
+func NewManagedConnection(dial func() (Transport, error)) (*ManagedConnection, error) {
+	t, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	mc := &ManagedConnection{
+		dial:     dial,
+		current:  t,
+		channels: map[*managedChannel]struct{}{},
+	}
+	mc.cond = sync.NewCond(&mc.mu)
+	go mc.watch(t)
+	return mc, nil
+}
+
+func (mc *ManagedConnection) watch(t Transport) {
+	for {
+		err, ok := <-t.NotifyClose(make(chan *amqp.Error, 1))
+		mc.mu.Lock()
+		if mc.closed {
+			mc.mu.Unlock()
+			return
+		}
+		mc.current = nil
+		mc.mu.Unlock()
+		if ok {
+			log.Printf("Connection lost -> %v, reconnecting... \n", err)
+		} else {
+			log.Println("Connection closed, reconnecting...")
+		}
+
+		t = mc.redial()
+		if t == nil {
+			return
+		}
+		log.Println("Reconnected!")
+	}
+}
+
+// redial keeps dialing until it succeeds or the connection is closed, in
+// which case it returns nil.
+func (mc *ManagedConnection) redial() Transport {
+	delay := reconnectMinDelay
+	for {
+		time.Sleep(delay)
+		t, err := mc.dial()
+		mc.mu.Lock()
+		if mc.closed {
+			mc.mu.Unlock()
+			if err == nil {
+				t.Close()
+			}
+			return nil
+		}
+		if err == nil {
+			mc.current = t
+			mc.cond.Broadcast()
+			mc.mu.Unlock()
+			return t
+		}
+		mc.mu.Unlock()
+		log.Printf("Could not reconnect -> %v \n", err)
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// transport blocks until the connection is up.
+func (mc *ManagedConnection) transport() (Transport, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	for mc.current == nil && !mc.closed {
+		mc.cond.Wait()
+	}
+	if mc.closed {
+		return nil, amqp.ErrClosed
+	}
+	return mc.current, nil
+}
+
+// Channel opens a channel that reopens itself after a connection or channel
+// failure. It blocks while the connection is down.
+func (mc *ManagedConnection) Channel() (Channel, error) {
+	t, err := mc.transport()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := t.Channel()
+	if err != nil {
+		return nil, err
+	}
+	ch := &managedChannel{conn: mc, consumers: map[string]*managedConsumer{}}
+	ch.cond = sync.NewCond(&ch.mu)
+
+	mc.mu.Lock()
+	if mc.closed {
+		mc.mu.Unlock()
+		raw.Close()
+		return nil, amqp.ErrClosed
+	}
+	mc.channels[ch] = struct{}{}
+	mc.mu.Unlock()
+
+	ch.install(raw)
+	return ch, nil
+}
+
+func (mc *ManagedConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.closed {
+		close(receiver)
+	} else {
+		mc.closes = append(mc.closes, receiver)
+	}
+	return receiver
+}
+
+// Close shuts the connection down for good, closing every managed channel.
+func (mc *ManagedConnection) Close() error {
+	mc.mu.Lock()
+	if mc.closed {
+		mc.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	mc.closed = true
+	t := mc.current
+	mc.current = nil
+	channels := mc.channels
+	mc.channels = nil
+	notifyClosed(mc.closes, nil)
+	mc.closes = nil
+	mc.cond.Broadcast()
+	mc.mu.Unlock()
+
+	for ch := range channels {
+		ch.Close()
+	}
+	if t != nil {
+		return t.Close()
+	}
+	return nil
+}
+
+func (mc *ManagedConnection) forget(ch *managedChannel) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	delete(mc.channels, ch)
+}
+
+// managedChannel records the topology declared through it so it can be
+// replayed on a fresh channel after a failure.
+type managedChannel struct {
+	conn *ManagedConnection
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	current   Channel
+	setup     []func(Channel) error
+	consumers map[string]*managedConsumer
+	closes    []chan *amqp.Error
+	closed    bool
+
+	confirms []chan amqp.Confirmation
+	returns  []chan amqp.Return
+	relaying bool
+	// tagBase is added to the delivery tags of the current underlying
+	// channel, which start again at 1, so tags keep counting up across
+	// reconnects.
+	tagBase    uint64
+	forwarders sync.WaitGroup
+}
+
+type managedConsumer struct {
+	queue     string
+	tag       string
+	autoAck   bool
+	exclusive bool
+	noLocal   bool
+	args      amqp.Table
+	sources   chan (<-chan amqp.Delivery)
+	out       chan amqp.Delivery
+}
+
+func (c *managedConsumer) consume(ch Channel) error {
+	deliveries, err := ch.Consume(c.queue, c.tag, c.autoAck, c.exclusive, c.noLocal, false, c.args)
+	if err != nil {
+		return err
+	}
+	c.sources <- deliveries
+	return nil
+}
+
+func (c *managedConsumer) run() {
+	defer close(c.out)
+	for deliveries := range c.sources {
+		for d := range deliveries {
+			c.out <- d
+		}
+	}
+}
+
+// install makes raw the live channel and starts watching it for failure.
+func (ch *managedChannel) install(raw Channel) {
+	closes := raw.NotifyClose(make(chan *amqp.Error, 1))
+	ch.mu.Lock()
+	ch.relaying = false
+	if len(ch.confirms) > 0 || len(ch.returns) > 0 {
+		ch.relay(raw)
+	}
+	ch.current = raw
+	ch.cond.Broadcast()
+	ch.mu.Unlock()
+	go ch.watch(raw, closes)
+}
+
+func (ch *managedChannel) watch(raw Channel, closes chan *amqp.Error) {
+	err, ok := <-closes
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return
+	}
+	ch.current = nil
+	ch.tagBase += raw.GetNextPublishSeqNo() - 1
+	ch.mu.Unlock()
+	if ok {
+		log.Printf("Channel closed -> %v, reopening... \n", err)
+	}
+	ch.recover()
+}
+
+// recover opens a new underlying channel and replays the recorded topology
+// and consumers on it, retrying with backoff until it succeeds or the
+// channel is closed.
+func (ch *managedChannel) recover() {
+	delay := reconnectMinDelay
+	for {
+		raw, err := ch.reopen()
+		if err == nil {
+			ch.install(raw)
+			return
+		}
+		ch.mu.Lock()
+		closed := ch.closed
+		ch.mu.Unlock()
+		if closed {
+			return
+		}
+		log.Printf("Could not reopen channel -> %v \n", err)
+		time.Sleep(delay)
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+func (ch *managedChannel) reopen() (Channel, error) {
+	t, err := ch.conn.transport()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := t.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		raw.Close()
+		return nil, amqp.ErrClosed
+	}
+	for _, step := range ch.setup {
+		if err := step(raw); err != nil {
+			raw.Close()
+			return nil, err
+		}
+	}
+	for _, c := range ch.consumers {
+		if err := c.consume(raw); err != nil {
+			raw.Close()
+			return nil, fmt.Errorf("could not resume consumer %s: %w", c.tag, err)
+		}
+	}
+	return raw, nil
+}
+
+// relay forwards raw's confirms and returns to the listeners registered on
+// the managed channel. Returns already waiting are always passed on before
+// the next confirm, keeping the order the broker sent them in. ch.mu must be
+// held.
+func (ch *managedChannel) relay(raw Channel) {
+	confirms := raw.NotifyPublish(make(chan amqp.Confirmation, notifyBuffer))
+	returns := raw.NotifyReturn(make(chan amqp.Return, notifyBuffer))
+	base := ch.tagBase
+	ch.relaying = true
+	ch.forwarders.Add(1)
+	go func() {
+		defer ch.forwarders.Done()
+		for confirms != nil || returns != nil {
+			select {
+			case r, ok := <-returns:
+				if !ok {
+					returns = nil
+					continue
+				}
+				ch.forwardReturn(r)
+			case c, ok := <-confirms:
+				if !ok {
+					confirms = nil
+					continue
+				}
+				for pending := true; pending && returns != nil; {
+					select {
+					case r, ok := <-returns:
+						if !ok {
+							returns = nil
+							continue
+						}
+						ch.forwardReturn(r)
+					default:
+						pending = false
+					}
+				}
+				c.DeliveryTag += base
+				ch.forwardConfirm(c)
+			}
+		}
+	}()
+}
+
+func (ch *managedChannel) forwardReturn(r amqp.Return) {
+	ch.mu.Lock()
+	listeners := append([]chan amqp.Return{}, ch.returns...)
+	ch.mu.Unlock()
+	for _, l := range listeners {
+		l <- r
+	}
+}
+
+func (ch *managedChannel) forwardConfirm(c amqp.Confirmation) {
+	ch.mu.Lock()
+	listeners := append([]chan amqp.Confirmation{}, ch.confirms...)
+	ch.mu.Unlock()
+	for _, l := range listeners {
+		l <- c
+	}
+}
+
+// live waits until the channel is usable.
+func (ch *managedChannel) live(ctx context.Context) (Channel, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() {
+			ch.mu.Lock()
+			defer ch.mu.Unlock()
+			ch.cond.Broadcast()
+		})
+		defer stop()
+	}
+	for ch.current == nil && !ch.closed && ctx.Err() == nil {
+		ch.cond.Wait()
+	}
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ch.current, nil
+}
+
+// declare runs step now and records it for replay after a reconnect.
+func (ch *managedChannel) declare(step func(Channel) error) error {
+	raw, err := ch.live(context.Background())
+	if err != nil {
+		return err
+	}
+	if err := step(raw); err != nil {
+		return err
+	}
+	ch.mu.Lock()
+	ch.setup = append(ch.setup, step)
+	ch.mu.Unlock()
+	return nil
+}
+
+func (ch *managedChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	return ch.declare(func(raw Channel) error {
+		return raw.ExchangeDeclare(name, kind, durable, autoDelete, internal, noWait, args)
+	})
+}
+
+func (ch *managedChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	var queue amqp.Queue
+	err := ch.declare(func(raw Channel) error {
+		q, err := raw.QueueDeclare(name, durable, autoDelete, exclusive, noWait, args)
+		queue = q
+		return err
+	})
+	return queue, err
+}
+
+// QueueDeclarePassive only looks the queue up, so it isn't replayed after a
+// reconnect.
+func (ch *managedChannel) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	raw, err := ch.live(context.Background())
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	return raw.QueueDeclarePassive(name, durable, autoDelete, exclusive, noWait, args)
+}
+
+func (ch *managedChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	return ch.declare(func(raw Channel) error {
+		return raw.QueueBind(name, key, exchange, noWait, args)
+	})
+}
+
+func (ch *managedChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	return ch.declare(func(raw Channel) error {
+		return raw.Qos(prefetchCount, prefetchSize, global)
+	})
+}
+
+// Consume returns a delivery channel that stays open across reconnects. It is
+// closed only when the managed channel itself is closed.
+func (ch *managedChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	if consumer == "" {
+		consumer = newConsumerTag()
+	}
+	c := &managedConsumer{
+		queue:     queue,
+		tag:       consumer,
+		autoAck:   autoAck,
+		exclusive: exclusive,
+		noLocal:   noLocal,
+		args:      args,
+		sources:   make(chan (<-chan amqp.Delivery), 1),
+		out:       make(chan amqp.Delivery),
+	}
+	raw, err := ch.live(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if err := c.consume(raw); err != nil {
+		return nil, err
+	}
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return nil, amqp.ErrClosed
+	}
+	ch.consumers[consumer] = c
+	ch.mu.Unlock()
+	go c.run()
+	return c.out, nil
+}
+
+// PublishWithContext blocks while the connection is down and publishes once
+// it is back, unless ctx is done first.
+func (ch *managedChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	for {
+		raw, err := ch.live(ctx)
+		if err != nil {
+			return err
+		}
+		err = raw.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+		if err != amqp.ErrClosed {
+			return err
+		}
+		ch.mu.Lock()
+		if ch.current == raw {
+			ch.current = nil
+		}
+		ch.mu.Unlock()
+	}
+}
+
+// Cancel stops a consumer for good; it is not resumed after a reconnect.
+func (ch *managedChannel) Cancel(consumer string, noWait bool) error {
+	ch.mu.Lock()
+	c, ok := ch.consumers[consumer]
+	if ok {
+		delete(ch.consumers, consumer)
+	}
+	raw := ch.current
+	ch.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	var err error
+	if raw != nil {
+		err = raw.Cancel(consumer, noWait)
+	}
+	close(c.sources)
+	return err
+}
+
+// Get waits for the connection to be up. Deliveries it returns belong to the
+// underlying channel, so they can't be acked after a reconnect.
+func (ch *managedChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	raw, err := ch.live(context.Background())
+	if err != nil {
+		return amqp.Delivery{}, false, err
+	}
+	return raw.Get(queue, autoAck)
+}
+
+func (ch *managedChannel) Confirm(noWait bool) error {
+	return ch.declare(func(raw Channel) error {
+		return raw.Confirm(noWait)
+	})
+}
+
+// GetNextPublishSeqNo is the delivery tag the next publish will be
+// confirmed with.
+func (ch *managedChannel) GetNextPublishSeqNo() uint64 {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.current == nil {
+		return ch.tagBase + 1
+	}
+	return ch.tagBase + ch.current.GetNextPublishSeqNo()
+}
+
+// NotifyPublish registers a listener that keeps receiving confirms across
+// reconnects. Delivery tags carry on from where the last underlying channel
+// left off, so no two publishes share one.
+func (ch *managedChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		close(confirm)
+		return confirm
+	}
+	ch.confirms = append(ch.confirms, confirm)
+	if ch.current != nil && !ch.relaying {
+		ch.relay(ch.current)
+	}
+	return confirm
+}
+
+func (ch *managedChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		close(c)
+		return c
+	}
+	ch.returns = append(ch.returns, c)
+	if ch.current != nil && !ch.relaying {
+		ch.relay(ch.current)
+	}
+	return c
+}
+
+func (ch *managedChannel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		close(receiver)
+	} else {
+		ch.closes = append(ch.closes, receiver)
+	}
+	return receiver
+}
+
+func (ch *managedChannel) Close() error {
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	ch.closed = true
+	raw := ch.current
+	ch.current = nil
+	for _, c := range ch.consumers {
+		close(c.sources)
+	}
+	ch.consumers = nil
+	notifyClosed(ch.closes, nil)
+	ch.closes = nil
+	ch.cond.Broadcast()
+	ch.mu.Unlock()
+
+	ch.conn.forget(ch)
+	var err error
+	if raw != nil {
+		err = raw.Close()
+	}
+	ch.forwarders.Wait()
+	ch.mu.Lock()
+	for _, l := range ch.confirms {
+		close(l)
+	}
+	for _, l := range ch.returns {
+		close(l)
+	}
+	ch.confirms, ch.returns = nil, nil
+	ch.mu.Unlock()
+	return err
+}
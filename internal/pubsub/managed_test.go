@@ -0,0 +1,132 @@
+package pubsub
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func nextDelivery(t *testing.T, deliveries <-chan amqp.Delivery) amqp.Delivery {
+	t.Helper()
+	select {
+	case d, ok := <-deliveries:
+		if !ok {
+			t.Fatal("deliveries closed")
+		}
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was delivered")
+		return amqp.Delivery{}
+	}
+}
+
+func nextConfirm(t *testing.T, confirms <-chan amqp.Confirmation) amqp.Confirmation {
+	t.Helper()
+	select {
+	case c := <-confirms:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was confirmed")
+		return amqp.Confirmation{}
+	}
+}
+
+func TestManagedConnectionSurvivesBrokerRestart(t *testing.T) {
+	b := NewMemoryBroker()
+	var dials atomic.Int32
+	mc, err := NewManagedConnection(func() (Transport, error) {
+		dials.Add(1)
+		return b.Connect(), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	ch, err := mc.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The queue is exclusive, so the restart deletes it and only the replayed
+	// topology brings it back.
+	const queue = "managed_test"
+	if err := ApplyTopology(ch, routing.PerilTopology()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.QueueDeclare(queue, false, true, true, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.QueueBind(queue, routing.ArmyMovesKey("managed").String(), routing.ExchangePerilTopic, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		t.Fatal(err)
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 4))
+	deliveries, err := ch.Consume(queue, "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PublishJSON(ch, routing.ExchangePerilTopic, routing.ArmyMovesKey("managed"), "before"); err != nil {
+		t.Fatal(err)
+	}
+	if c := nextConfirm(t, confirms); c.DeliveryTag != 1 || !c.Ack {
+		t.Fatalf("first publish confirmed as %+v, want tag 1 acked", c)
+	}
+	stale := nextDelivery(t, deliveries)
+
+	b.DropConnections()
+
+	// Publishing waits for the reconnect, and its tag carries on from the
+	// first channel's instead of starting again at 1.
+	if err := PublishJSON(ch, routing.ExchangePerilTopic, routing.ArmyMovesKey("managed"), "after"); err != nil {
+		t.Fatal(err)
+	}
+	if c := nextConfirm(t, confirms); c.DeliveryTag != 2 || !c.Ack {
+		t.Errorf("publish after reconnecting confirmed as %+v, want tag 2 acked", c)
+	}
+	if n := dials.Load(); n != 2 {
+		t.Errorf("dialed %d times, want 2", n)
+	}
+
+	// The delivery from before the restart belongs to the dead channel. Acking
+	// it must not reach the new one, where its tag means nothing.
+	var amqpErr *amqp.Error
+	if err := stale.Ack(false); errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		t.Fatalf("acking across the reconnect: %v", err)
+	}
+
+	// The consumer is back on the redeclared queue, and acking its
+	// deliveries leaves the new channel up.
+	fresh := nextDelivery(t, deliveries)
+	if string(fresh.Body) != `"after"` {
+		t.Errorf("got %s, want the message published after reconnecting", fresh.Body)
+	}
+	if err := fresh.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := PublishJSON(ch, routing.ExchangePerilTopic, routing.ArmyMovesKey("managed"), "again"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nextDelivery(t, deliveries).Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	if c := nextConfirm(t, confirms); c.DeliveryTag != 3 {
+		t.Errorf("third publish confirmed with tag %d, want 3", c.DeliveryTag)
+	}
+	if n := dials.Load(); n != 2 {
+		t.Errorf("dialed %d times, want no further reconnect", n)
+	}
+	q, err := ch.QueueDeclarePassive(queue, false, true, true, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Messages != 0 {
+		t.Errorf("%d messages left on %s, want none", q.Messages, queue)
+	}
+}
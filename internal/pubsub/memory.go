@@ -0,0 +1,992 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// MemoryBroker is an in-process broker that follows RabbitMQ's semantics for
+// everything Peril relies on: direct, topic and fanout exchanges, the default
+// exchange, bindings with * and # wildcards, prefetch, ack/nack/requeue and
+// dead-lettering through x-dead-letter-exchange, including messages that
+// outlive x-message-ttl or their own expiration.
+//
+// Each call to Connect returns an independent connection, so a server and any
+// number of clients can share one broker inside a single process.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	exchanges map[string]*memExchange
+	queues    map[string]*memQueue
+	conns     map[*memConnection]struct{}
+	lastID    int
+}
+
+type memExchange struct {
+	name     string
+	kind     string
+	bindings []memBinding
+}
+
+type memBinding struct {
+	queue *memQueue
+	key   string
+}
+
+type memQueue struct {
+	name       string
+	durable    bool
+	autoDelete bool
+	exclusive  bool
+	owner      *memConnection
+	args       amqp.Table
+	ready      []*memMessage
+	consumers  []*memConsumer
+	deleted    bool
+}
+
+type memMessage struct {
+	exchange    string
+	key         string
+	pub         amqp.Publishing
+	redelivered bool
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	b := &MemoryBroker{
+		exchanges: map[string]*memExchange{},
+		queues:    map[string]*memQueue{},
+		conns:     map[*memConnection]struct{}{},
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Connect opens a new connection to the broker. Exclusive queues declared
+// through it are deleted when it is closed.
+func (b *MemoryBroker) Connect() Transport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := &memConnection{broker: b, channels: map[*memChannel]struct{}{}}
+	b.conns[c] = struct{}{}
+	return c
+}
+
+// DropConnections force-closes every open connection with a
+// CONNECTION_FORCED error, the way a broker restart looks to clients.
+func (b *MemoryBroker) DropConnections() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.conns {
+		c.shutdown(&amqp.Error{
+			Code:   amqp.ConnectionForced,
+			Reason: "CONNECTION_FORCED - broker forced connection closure",
+		})
+	}
+}
+
+func (b *MemoryBroker) nextName(prefix string) string {
+	b.lastID++
+	return fmt.Sprintf("%s%d", prefix, b.lastID)
+}
+
+func (b *MemoryBroker) route(exchange, key string) ([]*memQueue, error) {
+	if exchange == "" {
+		if q, ok := b.queues[key]; ok {
+			return []*memQueue{q}, nil
+		}
+		return nil, nil
+	}
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return nil, &amqp.Error{
+			Code:   amqp.NotFound,
+			Reason: fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '/'", exchange),
+		}
+	}
+	queues := []*memQueue{}
+	seen := map[*memQueue]bool{}
+	for _, binding := range ex.bindings {
+		if seen[binding.queue] {
+			continue
+		}
+		var match bool
+		switch ex.kind {
+		case amqp.ExchangeDirect:
+			match = binding.key == key
+		case amqp.ExchangeTopic:
+			match = topicMatch(binding.key, key)
+		case amqp.ExchangeFanout:
+			match = true
+		}
+		if match {
+			seen[binding.queue] = true
+			queues = append(queues, binding.queue)
+		}
+	}
+	return queues, nil
+}
+
+func (b *MemoryBroker) enqueue(q *memQueue, m memMessage) {
+	q.ready = append(q.ready, &m)
+	if ttl, ok := messageTTL(q, m.pub); ok {
+		time.AfterFunc(ttl, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.expire(q, &m)
+		})
+	}
+	b.cond.Broadcast()
+}
+
+// messageTTL is the shorter of the queue's x-message-ttl and the message's
+// own expiration, if either is set.
+func messageTTL(q *memQueue, pub amqp.Publishing) (time.Duration, bool) {
+	ttl, ok := headerInt(q.args["x-message-ttl"])
+	if ms, err := strconv.Atoi(pub.Expiration); err == nil && (!ok || ms < ttl) {
+		ttl, ok = ms, true
+	}
+	return time.Duration(ttl) * time.Millisecond, ok
+}
+
+// expire dead-letters m if it is still waiting in q. Messages out with a
+// consumer are not expired.
+func (b *MemoryBroker) expire(q *memQueue, m *memMessage) {
+	if q.deleted {
+		return
+	}
+	for i, ready := range q.ready {
+		if ready == m {
+			q.ready = append(q.ready[:i], q.ready[i+1:]...)
+			b.deadLetter(q, m, "expired")
+			return
+		}
+	}
+}
+
+func (b *MemoryBroker) requeue(q *memQueue, m *memMessage) {
+	if q.deleted {
+		return
+	}
+	m.redelivered = true
+	q.ready = append([]*memMessage{m}, q.ready...)
+	b.cond.Broadcast()
+}
+
+// deadLetter republishes m to the queue's dead-letter exchange, if it has one,
+// recording the reason in the x-death header the way RabbitMQ does.
+func (b *MemoryBroker) deadLetter(q *memQueue, m *memMessage, reason string) {
+	dlx, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	key := m.key
+	if dlk, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		key = dlk
+	}
+
+	headers := amqp.Table{}
+	for k, v := range m.pub.Headers {
+		headers[k] = v
+	}
+	deaths, _ := headers["x-death"].([]interface{})
+	count := int64(1)
+	rest := []interface{}{}
+	for _, d := range deaths {
+		death, ok := d.(amqp.Table)
+		if ok && death["queue"] == q.name && death["reason"] == reason {
+			if n, ok := death["count"].(int64); ok {
+				count = n + 1
+			}
+			continue
+		}
+		rest = append(rest, d)
+	}
+	headers["x-death"] = append([]interface{}{amqp.Table{
+		"count":        count,
+		"reason":       reason,
+		"queue":        q.name,
+		"time":         time.Now(),
+		"exchange":     m.exchange,
+		"routing-keys": []interface{}{m.key},
+	}}, rest...)
+	if _, ok := headers["x-first-death-reason"]; !ok {
+		headers["x-first-death-reason"] = reason
+		headers["x-first-death-queue"] = q.name
+		headers["x-first-death-exchange"] = m.exchange
+	}
+
+	queues, err := b.route(dlx, key)
+	if err != nil {
+		// RabbitMQ silently drops messages whose DLX does not exist.
+		return
+	}
+	pub := m.pub
+	pub.Headers = headers
+	pub.Expiration = ""
+	for _, target := range queues {
+		b.enqueue(target, memMessage{exchange: dlx, key: key, pub: pub})
+	}
+}
+
+func (b *MemoryBroker) deleteQueue(q *memQueue) {
+	if q.deleted {
+		return
+	}
+	q.deleted = true
+	delete(b.queues, q.name)
+	for _, ex := range b.exchanges {
+		kept := ex.bindings[:0]
+		for _, binding := range ex.bindings {
+			if binding.queue != q {
+				kept = append(kept, binding)
+			}
+		}
+		ex.bindings = kept
+	}
+	for _, c := range append([]*memConsumer{}, q.consumers...) {
+		c.cancel()
+	}
+	q.ready = nil
+	b.cond.Broadcast()
+}
+
+type memConnection struct {
+	broker   *MemoryBroker
+	channels map[*memChannel]struct{}
+	closes   []chan *amqp.Error
+	closed   bool
+}
+
+func (c *memConnection) Channel() (Channel, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &memChannel{
+		broker:    b,
+		conn:      c,
+		unacked:   map[uint64]*memUnacked{},
+		consumers: map[string]*memConsumer{},
+	}
+	c.channels[ch] = struct{}{}
+	return ch, nil
+}
+
+func (c *memConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		close(receiver)
+	} else {
+		c.closes = append(c.closes, receiver)
+	}
+	return receiver
+}
+
+func (c *memConnection) Close() error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.shutdown(nil)
+	return nil
+}
+
+func (c *memConnection) shutdown(err *amqp.Error) {
+	b := c.broker
+	c.closed = true
+	delete(b.conns, c)
+	for ch := range c.channels {
+		ch.shutdown(err)
+	}
+	for _, q := range b.queues {
+		if q.exclusive && q.owner == c {
+			b.deleteQueue(q)
+		}
+	}
+	notifyClosed(c.closes, err)
+	c.closes = nil
+}
+
+// notifyClosed delivers err, if any, to each close listener and then closes
+// it. It runs on its own goroutine so slow listeners never hold up the broker.
+func notifyClosed(listeners []chan *amqp.Error, err *amqp.Error) {
+	go func() {
+		for _, l := range listeners {
+			if err != nil {
+				l <- err
+			}
+			close(l)
+		}
+	}()
+}
+
+type memChannel struct {
+	broker    *MemoryBroker
+	conn      *memConnection
+	closed    bool
+	prefetch  int
+	lastTag   uint64
+	unacked   map[uint64]*memUnacked
+	consumers map[string]*memConsumer
+	closes    []chan *amqp.Error
+
+	confirming bool
+	publishSeq uint64
+	confirms   []chan amqp.Confirmation
+	returns    []chan amqp.Return
+	notifier   *memNotifier
+}
+
+type memUnacked struct {
+	msg      *memMessage
+	queue    *memQueue
+	consumer *memConsumer
+}
+
+// fail closes the channel with a channel-level error, as RabbitMQ does.
+func (ch *memChannel) fail(err *amqp.Error) error {
+	ch.shutdown(err)
+	return err
+}
+
+func (ch *memChannel) shutdown(err *amqp.Error) {
+	if ch.closed {
+		return
+	}
+	ch.closed = true
+	for _, c := range ch.consumers {
+		c.cancel()
+	}
+	tags := make([]uint64, 0, len(ch.unacked))
+	for tag := range ch.unacked {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] > tags[j] })
+	for _, tag := range tags {
+		u := ch.unacked[tag]
+		delete(ch.unacked, tag)
+		ch.broker.requeue(u.queue, u.msg)
+	}
+	delete(ch.conn.channels, ch)
+	notifyClosed(ch.closes, err)
+	ch.closes = nil
+	if ch.notifier != nil {
+		confirms, returns := ch.confirms, ch.returns
+		ch.notifier.post(func() {
+			for _, l := range confirms {
+				close(l)
+			}
+			for _, l := range returns {
+				close(l)
+			}
+		})
+		ch.notifier.stop()
+	}
+	ch.confirms, ch.returns = nil, nil
+	ch.broker.cond.Broadcast()
+}
+
+// notify queues f behind any earlier notifications on this channel, so
+// returns and confirms reach listeners in the order the broker produced them.
+func (ch *memChannel) notify(f func()) {
+	if ch.notifier == nil {
+		ch.notifier = newMemNotifier()
+	}
+	ch.notifier.post(f)
+}
+
+func (ch *memChannel) checkAccess(q *memQueue) error {
+	if q.exclusive && q.owner != ch.conn {
+		return ch.fail(&amqp.Error{
+			Code:   amqp.ResourceLocked,
+			Reason: fmt.Sprintf("RESOURCE_LOCKED - cannot obtain exclusive access to locked queue '%s' in vhost '/'", q.name),
+		})
+	}
+	return nil
+}
+
+func (ch *memChannel) queue(name string) (*memQueue, error) {
+	q, ok := ch.broker.queues[name]
+	if !ok {
+		return nil, ch.fail(&amqp.Error{
+			Code:   amqp.NotFound,
+			Reason: fmt.Sprintf("NOT_FOUND - no queue '%s' in vhost '/'", name),
+		})
+	}
+	if err := ch.checkAccess(q); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (ch *memChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if name == "" {
+		return ch.fail(&amqp.Error{Code: amqp.AccessRefused, Reason: "ACCESS_REFUSED - operation not permitted on the default exchange"})
+	}
+	switch kind {
+	case amqp.ExchangeDirect, amqp.ExchangeTopic, amqp.ExchangeFanout:
+	default:
+		return ch.fail(&amqp.Error{Code: amqp.CommandInvalid, Reason: fmt.Sprintf("COMMAND_INVALID - unknown exchange type '%s'", kind)})
+	}
+	if ex, ok := b.exchanges[name]; ok {
+		if ex.kind != kind {
+			return ch.fail(&amqp.Error{
+				Code:   amqp.PreconditionFailed,
+				Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg 'type' for exchange '%s' in vhost '/'", name),
+			})
+		}
+		return nil
+	}
+	b.exchanges[name] = &memExchange{name: name, kind: kind}
+	return nil
+}
+
+func (ch *memChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	if name == "" {
+		name = b.nextName("amq.gen-")
+	}
+	if q, ok := b.queues[name]; ok {
+		if err := ch.checkAccess(q); err != nil {
+			return amqp.Queue{}, err
+		}
+		if q.durable != durable || q.autoDelete != autoDelete || q.exclusive != exclusive {
+			return amqp.Queue{}, ch.fail(&amqp.Error{
+				Code:   amqp.PreconditionFailed,
+				Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent arg for queue '%s' in vhost '/'", name),
+			})
+		}
+		return amqp.Queue{Name: name, Messages: len(q.ready), Consumers: len(q.consumers)}, nil
+	}
+	q := &memQueue{
+		name:       name,
+		durable:    durable,
+		autoDelete: autoDelete,
+		exclusive:  exclusive,
+		args:       args,
+	}
+	if exclusive {
+		q.owner = ch.conn
+	}
+	b.queues[name] = q
+	return amqp.Queue{Name: name}, nil
+}
+
+// QueueDeclarePassive ignores everything but the name, as RabbitMQ does.
+func (ch *memChannel) QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	q, err := ch.queue(name)
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+	return amqp.Queue{Name: name, Messages: len(q.ready), Consumers: len(q.consumers)}, nil
+}
+
+func (ch *memChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	q, err := ch.queue(name)
+	if err != nil {
+		return err
+	}
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return ch.fail(&amqp.Error{
+			Code:   amqp.NotFound,
+			Reason: fmt.Sprintf("NOT_FOUND - no exchange '%s' in vhost '/'", exchange),
+		})
+	}
+	for _, binding := range ex.bindings {
+		if binding.queue == q && binding.key == key {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, memBinding{queue: q, key: key})
+	return nil
+}
+
+func (ch *memChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.prefetch = prefetchCount
+	return nil
+}
+
+func (ch *memChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+	q, err := ch.queue(queue)
+	if err != nil {
+		return nil, err
+	}
+	if consumer == "" {
+		consumer = b.nextName("ctag-memory-")
+	}
+	if _, ok := ch.consumers[consumer]; ok {
+		return nil, ch.fail(&amqp.Error{Code: amqp.NotAllowed, Reason: fmt.Sprintf("NOT_ALLOWED - attempt to reuse consumer tag '%s'", consumer)})
+	}
+	c := &memConsumer{
+		tag:      consumer,
+		queue:    q,
+		ch:       ch,
+		autoAck:  autoAck,
+		prefetch: ch.prefetch,
+		out:      make(chan amqp.Delivery),
+		done:     make(chan struct{}),
+	}
+	ch.consumers[consumer] = c
+	q.consumers = append(q.consumers, c)
+	go c.run()
+	return c.out, nil
+}
+
+func (ch *memChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	queues, err := b.route(exchange, key)
+	if err != nil {
+		return ch.fail(err.(*amqp.Error))
+	}
+	for _, q := range queues {
+		b.enqueue(q, memMessage{exchange: exchange, key: key, pub: msg})
+	}
+	if mandatory && len(queues) == 0 && len(ch.returns) > 0 {
+		ret := amqp.Return{
+			ReplyCode:       amqp.NoRoute,
+			ReplyText:       "NO_ROUTE",
+			Exchange:        exchange,
+			RoutingKey:      key,
+			ContentType:     msg.ContentType,
+			ContentEncoding: msg.ContentEncoding,
+			Headers:         msg.Headers,
+			DeliveryMode:    msg.DeliveryMode,
+			Priority:        msg.Priority,
+			CorrelationId:   msg.CorrelationId,
+			ReplyTo:         msg.ReplyTo,
+			Expiration:      msg.Expiration,
+			MessageId:       msg.MessageId,
+			Timestamp:       msg.Timestamp,
+			Type:            msg.Type,
+			UserId:          msg.UserId,
+			AppId:           msg.AppId,
+			Body:            msg.Body,
+		}
+		listeners := append([]chan amqp.Return{}, ch.returns...)
+		ch.notify(func() {
+			for _, l := range listeners {
+				l <- ret
+			}
+		})
+	}
+	if ch.confirming {
+		ch.publishSeq++
+		confirmation := amqp.Confirmation{DeliveryTag: ch.publishSeq, Ack: true}
+		listeners := append([]chan amqp.Confirmation{}, ch.confirms...)
+		ch.notify(func() {
+			for _, l := range listeners {
+				l <- confirmation
+			}
+		})
+	}
+	return nil
+}
+
+func (ch *memChannel) Cancel(consumer string, noWait bool) error {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if c, ok := ch.consumers[consumer]; ok {
+		c.cancel()
+	}
+	return nil
+}
+
+func (ch *memChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.Delivery{}, false, amqp.ErrClosed
+	}
+	q, err := ch.queue(queue)
+	if err != nil {
+		return amqp.Delivery{}, false, err
+	}
+	if len(q.ready) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+	m := q.ready[0]
+	q.ready = q.ready[1:]
+	d := ch.deliver(m, q, nil, autoAck)
+	d.MessageCount = uint32(len(q.ready))
+	return d, true, nil
+}
+
+func (ch *memChannel) Confirm(noWait bool) error {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.confirming = true
+	return nil
+}
+
+func (ch *memChannel) GetNextPublishSeqNo() uint64 {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return ch.publishSeq + 1
+}
+
+func (ch *memChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		close(confirm)
+	} else {
+		ch.confirms = append(ch.confirms, confirm)
+	}
+	return confirm
+}
+
+func (ch *memChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		close(c)
+	} else {
+		ch.returns = append(ch.returns, c)
+	}
+	return c
+}
+
+func (ch *memChannel) Close() error {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.shutdown(nil)
+	return nil
+}
+
+func (ch *memChannel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		close(receiver)
+	} else {
+		ch.closes = append(ch.closes, receiver)
+	}
+	return receiver
+}
+
+// deliver hands m out on the channel, to consumer c or, when c is nil, to a
+// Get.
+func (ch *memChannel) deliver(m *memMessage, q *memQueue, c *memConsumer, autoAck bool) amqp.Delivery {
+	ch.lastTag++
+	if !autoAck {
+		ch.unacked[ch.lastTag] = &memUnacked{msg: m, queue: q, consumer: c}
+		if c != nil {
+			c.unacked++
+		}
+	}
+	var tag string
+	if c != nil {
+		tag = c.tag
+	}
+	return amqp.Delivery{
+		Acknowledger:    ch,
+		Headers:         m.pub.Headers,
+		ContentType:     m.pub.ContentType,
+		ContentEncoding: m.pub.ContentEncoding,
+		DeliveryMode:    m.pub.DeliveryMode,
+		Priority:        m.pub.Priority,
+		CorrelationId:   m.pub.CorrelationId,
+		ReplyTo:         m.pub.ReplyTo,
+		Expiration:      m.pub.Expiration,
+		MessageId:       m.pub.MessageId,
+		Timestamp:       m.pub.Timestamp,
+		Type:            m.pub.Type,
+		UserId:          m.pub.UserId,
+		AppId:           m.pub.AppId,
+		ConsumerTag:     tag,
+		DeliveryTag:     ch.lastTag,
+		Redelivered:     m.redelivered,
+		Exchange:        m.exchange,
+		RoutingKey:      m.key,
+		Body:            m.pub.Body,
+	}
+}
+
+// settle removes the outstanding deliveries named by tag and multiple.
+func (ch *memChannel) settle(tag uint64, multiple bool) ([]*memUnacked, error) {
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+	tags := []uint64{}
+	if multiple {
+		for t := range ch.unacked {
+			if tag == 0 || t <= tag {
+				tags = append(tags, t)
+			}
+		}
+		sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	} else if _, ok := ch.unacked[tag]; ok {
+		tags = append(tags, tag)
+	} else {
+		return nil, ch.fail(&amqp.Error{
+			Code:   amqp.PreconditionFailed,
+			Reason: fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", tag),
+		})
+	}
+	settled := make([]*memUnacked, 0, len(tags))
+	for _, t := range tags {
+		u := ch.unacked[t]
+		delete(ch.unacked, t)
+		if u.consumer != nil {
+			u.consumer.unacked--
+		}
+		settled = append(settled, u)
+	}
+	ch.broker.cond.Broadcast()
+	return settled, nil
+}
+
+func (ch *memChannel) Ack(tag uint64, multiple bool) error {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := ch.settle(tag, multiple)
+	return err
+}
+
+func (ch *memChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	b := ch.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	settled, err := ch.settle(tag, multiple)
+	if err != nil {
+		return err
+	}
+	for i := len(settled) - 1; i >= 0; i-- {
+		u := settled[i]
+		if requeue {
+			b.requeue(u.queue, u.msg)
+		} else if !u.queue.deleted {
+			b.deadLetter(u.queue, u.msg, "rejected")
+		}
+	}
+	return nil
+}
+
+func (ch *memChannel) Reject(tag uint64, requeue bool) error {
+	return ch.Nack(tag, false, requeue)
+}
+
+type memConsumer struct {
+	tag       string
+	queue     *memQueue
+	ch        *memChannel
+	autoAck   bool
+	prefetch  int
+	unacked   int
+	cancelled bool
+	out       chan amqp.Delivery
+	done      chan struct{}
+}
+
+func (c *memConsumer) ready() bool {
+	if len(c.queue.ready) == 0 {
+		return false
+	}
+	return c.autoAck || c.prefetch == 0 || c.unacked < c.prefetch
+}
+
+// cancel stops the consumer. The broker lock must be held.
+func (c *memConsumer) cancel() {
+	if c.cancelled {
+		return
+	}
+	c.cancelled = true
+	close(c.done)
+	delete(c.ch.consumers, c.tag)
+	q := c.queue
+	for i, other := range q.consumers {
+		if other == c {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	if q.autoDelete && len(q.consumers) == 0 {
+		c.ch.broker.deleteQueue(q)
+	}
+	c.ch.broker.cond.Broadcast()
+}
+
+func (c *memConsumer) run() {
+	b := c.ch.broker
+	defer close(c.out)
+	for {
+		b.mu.Lock()
+		for !c.cancelled && !c.ready() {
+			b.cond.Wait()
+		}
+		if c.cancelled {
+			b.mu.Unlock()
+			return
+		}
+		m := c.queue.ready[0]
+		c.queue.ready = c.queue.ready[1:]
+		d := c.ch.deliver(m, c.queue, c, c.autoAck)
+		b.mu.Unlock()
+
+		select {
+		case c.out <- d:
+		case <-c.done:
+			// The consumer went away before the delivery was received, so
+			// hand the message back to the queue.
+			b.mu.Lock()
+			if c.autoAck {
+				b.requeue(c.queue, m)
+			} else if u, ok := c.ch.unacked[d.DeliveryTag]; ok {
+				delete(c.ch.unacked, d.DeliveryTag)
+				c.unacked--
+				b.requeue(u.queue, u.msg)
+			}
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+// topicMatch reports whether a topic routing key matches a binding pattern,
+// where * matches exactly one word and # matches zero or more.
+func topicMatch(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if matchWords(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && matchWords(pattern[1:], key[1:])
+	default:
+		return len(key) > 0 && pattern[0] == key[0] && matchWords(pattern[1:], key[1:])
+	}
+}
+
+// memNotifier runs channel notifications one at a time, in order, on its own
+// goroutine so a slow listener never holds the broker lock.
+type memNotifier struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []func()
+	stopped bool
+}
+
+func newMemNotifier() *memNotifier {
+	n := &memNotifier{}
+	n.cond = sync.NewCond(&n.mu)
+	go n.run()
+	return n
+}
+
+func (n *memNotifier) post(f func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pending = append(n.pending, f)
+	n.cond.Signal()
+}
+
+// stop lets the notifier exit once everything already posted has run.
+func (n *memNotifier) stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.stopped = true
+	n.cond.Signal()
+}
+
+func (n *memNotifier) run() {
+	for {
+		n.mu.Lock()
+		for len(n.pending) == 0 && !n.stopped {
+			n.cond.Wait()
+		}
+		if len(n.pending) == 0 {
+			n.mu.Unlock()
+			return
+		}
+		f := n.pending[0]
+		n.pending = n.pending[1:]
+		n.mu.Unlock()
+		f()
+	}
+}
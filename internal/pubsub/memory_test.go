@@ -0,0 +1,184 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestTopicMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"army_moves.*", "army_moves.alice", true},
+		{"army_moves.*", "army_moves", false},
+		{"army_moves.*", "army_moves.alice.extra", false},
+		{"game_logs.#", "game_logs", true},
+		{"game_logs.#", "game_logs.alice.today", true},
+		{"#", "anything.at.all", true},
+		{"*.alice", "war.alice", true},
+		{"*.alice", "war.bob", false},
+		{"war.alice", "war.alice", true},
+		{"war.alice", "war.alicia", false},
+	}
+	for _, tt := range tests {
+		if got := topicMatch(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("topicMatch(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+// memoryChannel connects to a fresh broker and opens a channel on it.
+func memoryChannel(t *testing.T) (*MemoryBroker, Channel) {
+	t.Helper()
+	b := NewMemoryBroker()
+	conn := b.Connect()
+	t.Cleanup(func() { conn.Close() })
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b, ch
+}
+
+func publishBody(t *testing.T, ch Channel, exchange, key, body string) {
+	t.Helper()
+	if err := ch.PublishWithContext(context.Background(), exchange, key, false, false, amqp.Publishing{Body: []byte(body)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getBody(t *testing.T, ch Channel, queue string) (amqp.Delivery, bool) {
+	t.Helper()
+	d, ok, err := ch.Get(queue, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, ok
+}
+
+func TestMemoryBrokerRoutesByWildcard(t *testing.T) {
+	_, ch := memoryChannel(t)
+	ch.ExchangeDeclare("topic", amqp.ExchangeTopic, true, false, false, false, nil)
+	ch.QueueDeclare("moves", true, false, false, false, nil)
+	ch.QueueDeclare("logs", true, false, false, false, nil)
+	ch.QueueBind("moves", "army_moves.*", "topic", false, nil)
+	ch.QueueBind("logs", "game_logs.#", "topic", false, nil)
+
+	publishBody(t, ch, "topic", "army_moves.alice", "move")
+	publishBody(t, ch, "topic", "game_logs.alice.today", "log")
+	publishBody(t, ch, "topic", "nobody.listens", "lost")
+
+	if d, ok := getBody(t, ch, "moves"); !ok || string(d.Body) != "move" {
+		t.Errorf("moves got %q, %v; want move", d.Body, ok)
+	}
+	if d, ok := getBody(t, ch, "logs"); !ok || string(d.Body) != "log" {
+		t.Errorf("logs got %q, %v; want log", d.Body, ok)
+	}
+	for _, q := range []string{"moves", "logs"} {
+		if d, ok := getBody(t, ch, q); ok {
+			t.Errorf("%s got unexpected %q", q, d.Body)
+		}
+	}
+}
+
+func TestMemoryBrokerRequeuesAndDeadLetters(t *testing.T) {
+	_, ch := memoryChannel(t)
+	ch.ExchangeDeclare("direct", amqp.ExchangeDirect, true, false, false, false, nil)
+	ch.ExchangeDeclare("dlx", amqp.ExchangeFanout, true, false, false, false, nil)
+	ch.QueueDeclare("work", true, false, false, false, amqp.Table{"x-dead-letter-exchange": "dlx"})
+	ch.QueueDeclare("dead", true, false, false, false, nil)
+	ch.QueueBind("work", "job", "direct", false, nil)
+	ch.QueueBind("dead", "", "dlx", false, nil)
+
+	publishBody(t, ch, "direct", "job", "payload")
+
+	d, ok := getBody(t, ch, "work")
+	if !ok {
+		t.Fatal("work queue is empty")
+	}
+	if err := d.Nack(false, true); err != nil {
+		t.Fatal(err)
+	}
+	d, ok = getBody(t, ch, "work")
+	if !ok || !d.Redelivered {
+		t.Fatalf("requeued message: got %v, redelivered %v", ok, d.Redelivered)
+	}
+	if err := d.Nack(false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	d, ok = getBody(t, ch, "dead")
+	if !ok || string(d.Body) != "payload" {
+		t.Fatalf("dead queue got %q, %v; want payload", d.Body, ok)
+	}
+	deaths := Deaths(d.Headers)
+	if len(deaths) != 1 || deaths[0].Reason != "rejected" || deaths[0].Queue != "work" || deaths[0].Exchange != "direct" {
+		t.Errorf("x-death = %+v, want one rejection from work via direct", deaths)
+	}
+}
+
+func TestMemoryBrokerExpiresToDeadLetter(t *testing.T) {
+	_, ch := memoryChannel(t)
+	ch.ExchangeDeclare("dlx", amqp.ExchangeFanout, true, false, false, false, nil)
+	ch.QueueDeclare("short", true, false, false, false, amqp.Table{"x-dead-letter-exchange": "dlx", "x-message-ttl": int32(10)})
+	ch.QueueDeclare("dead", true, false, false, false, nil)
+	ch.QueueBind("dead", "", "dlx", false, nil)
+
+	publishBody(t, ch, "", "short", "stale")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if d, ok := getBody(t, ch, "dead"); ok {
+			if deaths := Deaths(d.Headers); len(deaths) != 1 || deaths[0].Reason != "expired" {
+				t.Errorf("x-death = %+v, want one expiry", deaths)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("message never expired")
+}
+
+func TestMemoryBrokerReturnsUnroutableMandatory(t *testing.T) {
+	_, ch := memoryChannel(t)
+	ch.ExchangeDeclare("topic", amqp.ExchangeTopic, true, false, false, false, nil)
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+
+	err := ch.PublishWithContext(context.Background(), "topic", "nobody.listens", true, false, amqp.Publishing{MessageId: "m1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-returns:
+		if r.MessageId != "m1" || r.ReplyCode != amqp.NoRoute {
+			t.Errorf("got return %+v, want m1 with NO_ROUTE", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no return")
+	}
+}
+
+func TestMemoryBrokerDeletesExclusiveQueuesWithConnection(t *testing.T) {
+	b := NewMemoryBroker()
+	owner := b.Connect()
+	ch, _ := owner.Channel()
+	if _, err := ch.QueueDeclare("mine", false, true, true, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := b.Connect().Channel()
+	if _, err := other.QueueDeclare("mine", false, true, true, false, nil); err == nil {
+		t.Fatal("another connection declared an exclusive queue it does not own")
+	}
+
+	owner.Close()
+	other, _ = b.Connect().Channel()
+	if _, err := other.QueueDeclare("mine", false, true, true, false, nil); err != nil {
+		t.Fatalf("queue outlived its exclusive owner: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package pubsub
+
+import (
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/metrics"
+)
+
+var (
+	publishedTotal = metrics.NewCounter(
+		"peril_published_total",
+		"Messages published, by exchange and routing key.",
+		"exchange", "routing_key",
+	)
+	publishFailures = metrics.NewCounter(
+		"peril_publish_failures_total",
+		"Publishes that failed, by exchange and routing key.",
+		"exchange", "routing_key",
+	)
+	deliveriesTotal = metrics.NewCounter(
+		"peril_deliveries_total",
+		"Deliveries received, by queue.",
+		"queue",
+	)
+	settledTotal = metrics.NewCounter(
+		"peril_deliveries_settled_total",
+		"Deliveries acked or nacked, by queue and outcome: ack, requeue or discard.",
+		"queue", "outcome",
+	)
+	retriesParked = metrics.NewCounter(
+		"peril_retries_parked_total",
+		"Deliveries parked after their last retry attempt, by queue.",
+		"queue",
+	)
+	poisonTotal = metrics.NewCounter(
+		"peril_poison_messages_total",
+		"Deliveries that could not be decoded, by queue and what was done with them.",
+		"queue", "action",
+	)
+	handlerDuration = metrics.NewHistogram(
+		"peril_handler_duration_seconds",
+		"Time spent in handlers, by queue.",
+		nil,
+		"queue",
+	)
+	decisionDuration = metrics.NewHistogram(
+		"peril_handler_decision_duration_seconds",
+		"Time spent in handlers wrapped in the Metrics middleware, by queue and act: ack, discard, requeue or retry.",
+		nil,
+		"queue", "act",
+	)
+	inflight = metrics.NewGauge(
+		"peril_inflight_deliveries",
+		"Deliveries received but not yet settled, by queue.",
+		"queue",
+	)
+	queueMessages = metrics.NewGauge(
+		"peril_queue_messages",
+		"Messages ready in each subscribed queue, as last polled from the broker.",
+		"queue",
+	)
+)
+
+// queueDepthInterval is how often subscriptions poll their queue's depth.
+var queueDepthInterval = 5 * time.Second
+
+// watchDepth keeps peril_queue_messages current for sub's queue until sub
+// stops polling, every interval. Each poll opens its own channel on conn: a
+// passive declare that fails closes the channel it was made on, and that must
+// not be the one sub consumes from.
+func watchDepth(conn Transport, sub *Subscription, ready int, interval time.Duration) {
+	defer close(sub.polled)
+	depth := queueMessages.With(sub.queue)
+	depth.Set(float64(ready))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sub.stopPoll:
+			return
+		case <-ticker.C:
+		}
+		ch, err := conn.Channel()
+		if err != nil {
+			continue
+		}
+		q, err := ch.QueueDeclarePassive(sub.queue, false, false, false, false, nil)
+		ch.Close()
+		if err != nil {
+			continue
+		}
+		depth.Set(float64(q.Messages))
+	}
+}
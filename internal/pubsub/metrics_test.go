@@ -0,0 +1,155 @@
+package pubsub
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestSubscriptionReportsQueueDepth(t *testing.T) {
+	defer func(d time.Duration) { queueDepthInterval = d }(queueDepthInterval)
+	queueDepthInterval = 10 * time.Millisecond
+
+	_, conn, ch := perilBroker(t)
+	const queue = "depth_test"
+	release := make(chan struct{})
+	sub, err := SubscribeJSONWithContext(
+		context.Background(),
+		conn,
+		routing.ExchangePerilTopic,
+		queue,
+		routing.ArmyMovesKey("depth").Pattern(),
+		Transient,
+		func(string) ActType {
+			<-release
+			return Ack
+		},
+		WithPrefetch(1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Stop(context.Background())
+	defer close(release)
+
+	// One message is held by the handler and two wait in the queue.
+	for i := 0; i < 3; i++ {
+		if err := PublishJSON(ch, routing.ExchangePerilTopic, routing.ArmyMovesKey("depth"), "hello"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	depth := queueMessages.With(queue)
+	deadline := time.Now().Add(2 * time.Second)
+	for depth.Value() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depth is %v, want 2", depth.Value())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// countingTransport counts the channels opened on it.
+type countingTransport struct {
+	Transport
+	channels atomic.Int32
+}
+
+func (t *countingTransport) Channel() (Channel, error) {
+	t.channels.Add(1)
+	return t.Transport.Channel()
+}
+
+func TestQueueDepthPollerStopsBeforeDraining(t *testing.T) {
+	defer func(d time.Duration) { queueDepthInterval = d }(queueDepthInterval)
+	queueDepthInterval = 5 * time.Millisecond
+
+	_, conn, _ := perilBroker(t)
+	counted := &countingTransport{Transport: conn}
+	sub, err := SubscribeJSONWithContext(
+		context.Background(),
+		counted,
+		routing.ExchangePerilTopic,
+		"poll_test",
+		routing.ArmyMovesKey("poll").Pattern(),
+		Transient,
+		func(string) ActType { return Ack },
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One channel to consume from, then one more for every poll.
+	deadline := time.Now().Add(2 * time.Second)
+	for counted.channels.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d channels opened, want the poller to open its own", counted.channels.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := sub.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	n := counted.channels.Load()
+	time.Sleep(10 * queueDepthInterval)
+	if after := counted.channels.Load(); after != n {
+		t.Errorf("%d channels opened after Stop, want the poller stopped", after-n)
+	}
+}
+
+func TestSubscriptionCountsSettlements(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	const queue = "settle_test"
+	acks, discards := settledTotal.With(queue, "ack"), settledTotal.With(queue, "discard")
+	handled := handlerDuration.With(queue)
+	acksBefore, discardsBefore, handledBefore := acks.Value(), discards.Value(), handled.Count()
+
+	// No Metrics middleware: settlements are counted all the same.
+	sub, err := SubscribeJSONWithContext(
+		context.Background(),
+		conn,
+		routing.ExchangePerilTopic,
+		queue,
+		routing.ArmyMovesKey("settle").Pattern(),
+		Transient,
+		func(s string) ActType {
+			switch s {
+			case "ack":
+				return Ack
+			case "discard":
+				return NackDiscard
+			default:
+				return ActType(42)
+			}
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Stop(context.Background())
+
+	for _, s := range []string{"ack", "discard", "unknown"} {
+		if err := PublishJSON(ch, routing.ExchangePerilTopic, routing.ArmyMovesKey("settle"), s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	poison := amqp.Publishing{ContentType: JSON.ContentType(), Body: []byte("{")}
+	if err := ch.PublishWithContext(context.Background(), routing.ExchangePerilTopic, routing.ArmyMovesKey("settle").String(), false, false, poison); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for acks.Value()-acksBefore < 1 || discards.Value()-discardsBefore < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("settled %v acks and %v discards, want 1 and 3 counting the unknown act and the poison message",
+				acks.Value()-acksBefore, discards.Value()-discardsBefore)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := handled.Count() - handledBefore; n != 3 {
+		t.Errorf("%d handler runs timed, want 3", n)
+	}
+}
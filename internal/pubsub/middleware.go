@@ -0,0 +1,119 @@
+package pubsub
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+)
+
+// Handler is a subscription handler seen from middleware: the decoded
+// message is passed as any, so one middleware fits every message type.
+type Handler func(message any, env Envelope) ActType
+
+// Middleware wraps a Handler with behavior of its own.
+type Middleware func(Handler) Handler
+
+// Chain composes middlewares so the first one listed is the outermost.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+func (a ActType) String() string {
+	switch a {
+	case Ack:
+		return "ack"
+	case NackRequeue:
+		return "requeue"
+	case NackDiscard:
+		return "discard"
+	case RetryLater:
+		return "retry"
+	default:
+		return fmt.Sprintf("ActType(%d)", int(a))
+	}
+}
+
+// Logging logs every message handled and what the handler decided. A nil
+// logger means slog.Default().
+func Logging(logger *slog.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(message any, env Envelope) ActType {
+			l := logger
+			if l == nil {
+				l = slog.Default()
+			}
+			start := time.Now()
+			act := next(message, env)
+			l.Info("handled message",
+				"message_id", env.MessageID,
+				"exchange", env.Exchange,
+				"routing_key", env.RoutingKey,
+				"sender", env.Sender,
+				"attempt", env.Attempt,
+				"act", act.String(),
+				"duration", time.Since(start),
+			)
+			return act
+		}
+	}
+}
+
+// Recover stops a panicking handler from taking the process down. The panic
+// is logged with its stack and the message is discarded to the dead-letter
+// exchange, since handling it again would most likely panic again.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(message any, env Envelope) (act ActType) {
+			defer func() {
+				if r := recover(); r != nil {
+					slog.Error("handler panicked",
+						"message_id", env.MessageID,
+						"routing_key", env.RoutingKey,
+						"panic", r,
+						"stack", string(debug.Stack()),
+					)
+					act = NackDiscard
+				}
+			}()
+			return next(message, env)
+		}
+	}
+}
+
+// Timing reports how long each message took to handle.
+func Timing(report func(env Envelope, act ActType, elapsed time.Duration)) Middleware {
+	return func(next Handler) Handler {
+		return func(message any, env Envelope) ActType {
+			start := time.Now()
+			act := next(message, env)
+			report(env, act, time.Since(start))
+			return act
+		}
+	}
+}
+
+// Metrics records how long the handler took by what it decided, which
+// subscriptions don't count on their own: they only see how each delivery
+// ended up settled, and a retry, say, settles with an ack.
+func Metrics() Middleware {
+	return Timing(func(env Envelope, act ActType, elapsed time.Duration) {
+		decisionDuration.With(env.Queue, act.String()).ObserveDuration(elapsed)
+	})
+}
+
+// Prompt prints prompt after each message, so the REPL prompt is redrawn
+// below whatever the handler printed.
+func Prompt(prompt string) Middleware {
+	return func(next Handler) Handler {
+		return func(message any, env Envelope) ActType {
+			defer fmt.Print(prompt)
+			return next(message, env)
+		}
+	}
+}
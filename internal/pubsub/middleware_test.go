@@ -0,0 +1,88 @@
+package pubsub
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// tag is middleware that notes its name on the way in and out.
+func tag(name string, trace *[]string) Middleware {
+	return func(next Handler) Handler {
+		return func(message any, env Envelope) ActType {
+			*trace = append(*trace, name+" in")
+			act := next(message, env)
+			*trace = append(*trace, name+" out")
+			return act
+		}
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var trace []string
+	h := Chain(tag("a", &trace), tag("b", &trace))(func(any, Envelope) ActType {
+		trace = append(trace, "handler")
+		return Ack
+	})
+	h(nil, Envelope{})
+	want := []string{"a in", "b in", "handler", "b out", "a out"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Errorf("got %v, want %v", trace, want)
+	}
+}
+
+func TestRecoverDiscards(t *testing.T) {
+	h := Recover()(func(any, Envelope) ActType { panic("boom") })
+	if act := h(nil, Envelope{}); act != NackDiscard {
+		t.Errorf("got %v, want discard", act)
+	}
+}
+
+func TestTimingReports(t *testing.T) {
+	var got []ActType
+	var elapsed time.Duration
+	h := Timing(func(env Envelope, act ActType, d time.Duration) {
+		if env.MessageID != "m1" {
+			t.Errorf("reported message %q, want m1", env.MessageID)
+		}
+		got = append(got, act)
+		elapsed = d
+	})(func(any, Envelope) ActType {
+		time.Sleep(10 * time.Millisecond)
+		return RetryLater
+	})
+
+	if act := h(nil, Envelope{MessageID: "m1"}); act != RetryLater {
+		t.Errorf("got %v, want the handler's retry", act)
+	}
+	if len(got) != 1 || got[0] != RetryLater {
+		t.Errorf("reported %v, want one retry", got)
+	}
+	if elapsed < 10*time.Millisecond {
+		t.Errorf("reported %v, want at least the 10ms the handler slept", elapsed)
+	}
+}
+
+func TestMetricsCountsDecisions(t *testing.T) {
+	const queue = "metrics_test"
+	acks, discards := decisionDuration.With(queue, "ack"), decisionDuration.With(queue, "discard")
+	acksBefore, discardsBefore := acks.Count(), discards.Count()
+	acts := []ActType{Ack, Ack, NackDiscard}
+	h := Chain(Metrics(), Recover())(func(_ any, env Envelope) ActType {
+		act := acts[0]
+		acts = acts[1:]
+		if act == NackDiscard {
+			panic("boom")
+		}
+		return act
+	})
+	for i := 0; i < 3; i++ {
+		h(nil, Envelope{Queue: queue})
+	}
+	if n := acks.Count() - acksBefore; n != 2 {
+		t.Errorf("%v acks timed, want 2", n)
+	}
+	if n := discards.Count() - discardsBefore; n != 1 {
+		t.Errorf("%v discards timed, want 1 for the panic", n)
+	}
+}
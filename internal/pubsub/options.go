@@ -0,0 +1,77 @@
+package pubsub
+
+// SubscribeOption tunes a subscription started by one of the Subscribe
+// functions.
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	retry    *RetryPolicy
+	prefetch int
+	workers  int
+	ordered  bool
+	chain    []Middleware
+	poison   PoisonPolicy
+	// undecodable is told about every delivery that can't be decoded,
+	// before the poison policy deals with it.
+	undecodable func(env Envelope, err error)
+}
+
+func newSubscribeOptions(opts []SubscribeOption) subscribeOptions {
+	o := subscribeOptions{prefetch: 10, workers: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithRetry makes handlers' RetryLater redeliver the message after a delay
+// chosen by policy. Without it, RetryLater requeues straight away.
+func WithRetry(policy RetryPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.retry = &policy
+	}
+}
+
+// WithPrefetch sets how many unacked deliveries the broker lets the
+// subscription hold at once. The default is 10. It should be at least the
+// number of workers, or some of them will sit idle.
+func WithPrefetch(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.prefetch = n
+	}
+}
+
+// WithWorkers runs the handler on n goroutines at once instead of one. The
+// handler must then be safe for concurrent use, and messages may finish out
+// of order unless WithOrderedKeys is also given.
+func WithWorkers(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.workers = max(n, 1)
+	}
+}
+
+// WithOrderedKeys keeps messages with the same routing key in order by
+// always handing them to the same worker. Different keys still run in
+// parallel.
+func WithOrderedKeys() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.ordered = true
+	}
+}
+
+// WithMiddleware wraps the subscription's handler in middlewares, the first
+// one listed outermost. It can be given more than once; later middlewares
+// wrap inside earlier ones.
+func WithMiddleware(middlewares ...Middleware) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.chain = append(o.chain, middlewares...)
+	}
+}
+
+// WithPoisonPolicy sets what happens to deliveries that can't be decoded.
+// The default is PoisonDiscard.
+func WithPoisonPolicy(policy PoisonPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.poison = policy
+	}
+}
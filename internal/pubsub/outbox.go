@@ -0,0 +1,195 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/outbox"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// relayPolicy spaces out attempts to publish the oldest pending entry while
+// the broker is unreachable. MaxAttempts is not used: the relay never gives
+// up on an entry it could not deliver.
+var relayPolicy = RetryPolicy{
+	InitialDelay: 500 * time.Millisecond,
+	Multiplier:   2,
+	MaxDelay:     30 * time.Second,
+}
+
+// Outbox is a Publisher that writes messages to a journal instead of the
+// broker and relays them from there in the background. A publish through it
+// returns once the message is on disk, so state changed after a successful
+// publish can't drift from what the rest of the game hears, even if the
+// connection or the process dies first. Messages are relayed in order and
+// at least once, keeping their message IDs across attempts. One the broker
+// can't route is parked in the journal rather than sent again.
+type Outbox struct {
+	journal  *outbox.Journal
+	pub      Publisher
+	onParked func(outbox.Entry)
+
+	wake    chan struct{}
+	ctx     context.Context
+	stop    context.CancelFunc
+	stopped chan struct{}
+}
+
+// NewOutbox relays the journal's entries, including any left from an
+// earlier run, through pub. Use a ConfirmedPublisher, or the relay can't
+// tell a message was lost. onParked, if not nil, is called from the relay
+// with each message it parks, since the publish that sent it has long since
+// returned.
+func NewOutbox(journal *outbox.Journal, pub Publisher, onParked func(outbox.Entry)) *Outbox {
+	ctx, stop := context.WithCancel(context.Background())
+	o := &Outbox{
+		journal:  journal,
+		pub:      pub,
+		onParked: onParked,
+		wake:     make(chan struct{}, 1),
+		ctx:      ctx,
+		stop:     stop,
+		stopped:  make(chan struct{}),
+	}
+	go o.relay()
+	o.notify()
+	return o
+}
+
+// PublishWithContext records msg in the journal. mandatory and immediate are
+// ignored: the relay always publishes as mandatory.
+func (o *Outbox) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if msg.MessageId == "" {
+		msg = stamp(ctx, msg)
+	}
+	err := o.journal.Append(outbox.Entry{
+		ID:            msg.MessageId,
+		Exchange:      exchange,
+		Key:           key,
+		ContentType:   msg.ContentType,
+		CorrelationID: msg.CorrelationId,
+		AppID:         msg.AppId,
+		Timestamp:     msg.Timestamp,
+		Headers:       msg.Headers,
+		Body:          msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+	o.notify()
+	return nil
+}
+
+func (o *Outbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) relay() {
+	defer close(o.stopped)
+	for {
+		select {
+		case <-o.wake:
+		case <-o.ctx.Done():
+			return
+		}
+		if !o.drain() {
+			return
+		}
+	}
+}
+
+// drain publishes pending entries oldest first, retrying the oldest until
+// it goes through or is parked. It returns false if the outbox was closed
+// meanwhile.
+func (o *Outbox) drain() bool {
+entries:
+	for _, e := range o.journal.Pending() {
+		for attempt := 1; ; attempt++ {
+			err := o.publish(e)
+			if err == nil {
+				break
+			}
+			if o.ctx.Err() != nil {
+				return false
+			}
+			if errors.Is(err, ErrPublishUnroutable) {
+				// The broker took it but nobody is listening; sending it
+				// again won't change that.
+				o.park(e, err)
+				continue entries
+			}
+			log.Printf("Could not relay outbox message %s -> %v \n", e.ID, err)
+			select {
+			case <-time.After(relayPolicy.Delay(attempt)):
+			case <-o.ctx.Done():
+				return false
+			}
+		}
+		if err := o.journal.Done(e.ID); err != nil {
+			log.Printf("Could not mark outbox message %s as sent -> %v \n", e.ID, err)
+		}
+	}
+	return true
+}
+
+func (o *Outbox) park(e outbox.Entry, err error) {
+	log.Printf("Parking outbox message %s -> %v \n", e.ID, err)
+	e.Parked = err.Error()
+	if err := o.journal.Park(e.ID, e.Parked); err != nil {
+		log.Printf("Could not park outbox message %s -> %v \n", e.ID, err)
+	}
+	if o.onParked != nil {
+		o.onParked(e)
+	}
+}
+
+func (o *Outbox) publish(e outbox.Entry) error {
+	headers := amqp.Table{}
+	for k, v := range e.Headers {
+		headers[k] = v
+	}
+	return o.pub.PublishWithContext(o.ctx, e.Exchange, e.Key, true, false, amqp.Publishing{
+		ContentType:   e.ContentType,
+		MessageId:     e.ID,
+		CorrelationId: e.CorrelationID,
+		AppId:         e.AppID,
+		Timestamp:     e.Timestamp,
+		Headers:       headers,
+		Body:          e.Body,
+	})
+}
+
+// Pending reports how many messages are waiting to be relayed.
+func (o *Outbox) Pending() int {
+	return len(o.journal.Pending())
+}
+
+// Parked returns the messages the relay gave up on because the broker could
+// not route them, from this run and earlier ones.
+func (o *Outbox) Parked() []outbox.Entry {
+	return o.journal.Parked()
+}
+
+// Close stops the relay, waiting up to ctx for what is pending to go out
+// first. Whatever is left stays in the journal for next time. It does not
+// close the journal or the publisher.
+func (o *Outbox) Close(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	var err error
+	for o.Pending() > 0 && err == nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	o.stop()
+	<-o.stopped
+	return err
+}
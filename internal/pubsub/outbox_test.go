@@ -0,0 +1,64 @@
+package pubsub
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/outbox"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestOutboxParksUnroutable(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	if _, _, err := DeclareAndBind(conn, routing.ExchangePerilTopic, routing.WarQueue("alice"), routing.WarKey("alice").Pattern(), Durable); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewConfirmedPublisher(conn, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	path := filepath.Join(t.TempDir(), "peril.outbox")
+	journal, err := outbox.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parked := make(chan outbox.Entry, 1)
+	box := NewOutbox(journal, p, func(e outbox.Entry) { parked <- e })
+
+	if err := PublishJSON(box, routing.ExchangePerilTopic, routing.WarKey("bob"), "lost"); err != nil {
+		t.Fatal(err)
+	}
+	if err := PublishJSON(box, routing.ExchangePerilTopic, routing.WarKey("alice"), "war"); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	waitGet(t, ch, routing.WarQueue("alice"), &got)
+	select {
+	case e := <-parked:
+		if e.Key != routing.WarKey("bob").String() || e.Parked == "" {
+			t.Errorf("parked %+v, want the message to bob with a reason", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing was parked")
+	}
+	if err := box.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	journal.Close()
+
+	// The parked message outlives the session without being sent again.
+	journal, err = outbox.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+	if n := len(journal.Pending()); n != 0 {
+		t.Errorf("%d messages pending after reopening, want none", n)
+	}
+	if got := journal.Parked(); len(got) != 1 || got[0].Key != routing.WarKey("bob").String() {
+		t.Errorf("parked after reopening: %+v, want the message to bob", got)
+	}
+}
@@ -0,0 +1,128 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// HeaderDecodeError carries the decode error on quarantined messages.
+const HeaderDecodeError = "x-peril-decode-error"
+
+type poisonAction int
+
+const (
+	poisonDiscard poisonAction = iota
+	poisonQuarantine
+	poisonCallback
+)
+
+// ErrTooManyRequeues is the error poison policies are given for messages the
+// handler kept requeueing.
+var ErrTooManyRequeues = errors.New("handler requeued the message too many times")
+
+// PoisonPolicy decides what a subscription does with a delivery it can't
+// decode, and with AfterRequeues, one its handler keeps requeueing.
+type PoisonPolicy struct {
+	action      poisonAction
+	callback    func(body []byte, env Envelope, err error) ActType
+	maxRequeues int
+}
+
+var (
+	// PoisonDiscard rejects the delivery so it goes to the dead-letter
+	// exchange. It is the default.
+	PoisonDiscard = PoisonPolicy{action: poisonDiscard}
+	// PoisonQuarantine moves the raw delivery to <queue>.quarantine with the
+	// decode error in its x-peril-decode-error header.
+	PoisonQuarantine = PoisonPolicy{action: poisonQuarantine}
+)
+
+// PoisonCallback hands undecodable deliveries to f, which decides what to do
+// with them like a handler would.
+func PoisonCallback(f func(body []byte, env Envelope, err error) ActType) PoisonPolicy {
+	return PoisonPolicy{action: poisonCallback, callback: f}
+}
+
+// AfterRequeues makes the policy also take messages the handler has already
+// requeued n times, instead of requeueing them again, so one that fails
+// every time doesn't loop for good. Requeues are counted by message ID
+// within the subscription, so the count starts over when it does.
+func (p PoisonPolicy) AfterRequeues(n int) PoisonPolicy {
+	p.maxRequeues = n
+	return p
+}
+
+// requeueCounts is how many times the handler has requeued each message, by
+// message ID. Messages without one aren't counted.
+type requeueCounts struct {
+	mu sync.Mutex
+	n  map[string]int
+}
+
+func (c *requeueCounts) add(id string) int {
+	if id == "" {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.n == nil {
+		c.n = map[string]int{}
+	}
+	c.n[id]++
+	return c.n[id]
+}
+
+func (c *requeueCounts) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.n, id)
+}
+
+// PoisonStats counts undecodable deliveries by what was done with them.
+type PoisonStats struct {
+	Discarded   atomic.Int64
+	Quarantined atomic.Int64
+	Callbacks   atomic.Int64
+}
+
+// QuarantineQueueName is where PoisonQuarantine puts queue's undecodable
+// deliveries.
+func QuarantineQueueName(queue string) string {
+	return queue + ".quarantine"
+}
+
+// declareQuarantine declares queue's quarantine queue, which lives as long as
+// queue does.
+func declareQuarantine(ch Channel, queue string, queueType SimpleQueueType) error {
+	_, err := ch.QueueDeclare(
+		QuarantineQueueName(queue),
+		queueType == Durable,
+		false,
+		queueType == Transient,
+		false,
+		nil,
+	)
+	return err
+}
+
+// quarantine copies d to queue's quarantine queue. The caller acks d once
+// this succeeds.
+func quarantine(ch Publisher, d amqp.Delivery, queue string, decodeErr error) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	if _, ok := headers[HeaderOriginalExchange]; !ok {
+		headers[HeaderOriginalExchange] = d.Exchange
+		headers[HeaderOriginalRoutingKey] = d.RoutingKey
+	}
+	headers[HeaderDecodeError] = decodeErr.Error()
+
+	msg := publishingFrom(d)
+	msg.Headers = headers
+	return ch.PublishWithContext(context.Background(), "", QuarantineQueueName(queue), false, false, msg)
+}
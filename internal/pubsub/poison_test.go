@@ -0,0 +1,152 @@
+package pubsub
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// subscribePoison subscribes queue under policy with handler.
+func subscribePoison(t *testing.T, conn Transport, queue string, policy PoisonPolicy, handler func(string) ActType) *Subscription {
+	t.Helper()
+	sub, err := SubscribeJSONWithContext(
+		context.Background(),
+		conn,
+		routing.ExchangePerilTopic,
+		queue,
+		routing.ArmyMovesKey("poison").Pattern(),
+		Transient,
+		handler,
+		WithPoisonPolicy(policy),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Stop(context.Background()) })
+	return sub
+}
+
+func publishRaw(t *testing.T, ch Channel, key routing.Key, body string) {
+	t.Helper()
+	msg := amqp.Publishing{ContentType: JSON.ContentType(), MessageId: newMessageID(), Body: []byte(body)}
+	if err := ch.PublishWithContext(context.Background(), routing.ExchangePerilTopic, key.String(), false, false, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitQuarantined waits for a delivery on queue's quarantine queue.
+func waitQuarantined(t *testing.T, ch Channel, queue string) amqp.Delivery {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		d, ok, err := ch.Get(QuarantineQueueName(queue), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			return d
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("nothing was quarantined from %s", queue)
+	return amqp.Delivery{}
+}
+
+func TestPoisonQuarantinesUndecodable(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	const queue = "quarantine_test"
+	counted := poisonTotal.With(queue, "quarantine")
+	before := counted.Value()
+	sub := subscribePoison(t, conn, queue, PoisonQuarantine, func(string) ActType { return Ack })
+
+	publishRaw(t, ch, routing.ArmyMovesKey("poison"), "{")
+	d := waitQuarantined(t, ch, queue)
+	if string(d.Body) != "{" {
+		t.Errorf("quarantined %q, want the raw body", d.Body)
+	}
+	if reason, _ := d.Headers[HeaderDecodeError].(string); reason == "" {
+		t.Error("no decode error header")
+	}
+	if key := routing.ArmyMovesKey("poison").String(); d.Headers[HeaderOriginalRoutingKey] != key {
+		t.Errorf("original routing key %v, want %s", d.Headers[HeaderOriginalRoutingKey], key)
+	}
+	if n := sub.PoisonStats().Quarantined.Load(); n != 1 {
+		t.Errorf("%d quarantined, want 1", n)
+	}
+	if n := counted.Value() - before; n != 1 {
+		t.Errorf("%v quarantines counted, want 1", n)
+	}
+}
+
+func TestPoisonQuarantinesAfterRequeues(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	const queue = "requeue_limit_test"
+	counted := poisonTotal.With(queue, "quarantine")
+	before := counted.Value()
+	handled := make(chan string, 10)
+	sub := subscribePoison(t, conn, queue, PoisonQuarantine.AfterRequeues(3), func(s string) ActType {
+		handled <- s
+		return NackRequeue
+	})
+
+	publishRaw(t, ch, routing.ArmyMovesKey("poison"), `"fails every time"`)
+	d := waitQuarantined(t, ch, queue)
+	if reason, _ := d.Headers[HeaderDecodeError].(string); !strings.Contains(reason, ErrTooManyRequeues.Error()) {
+		t.Errorf("quarantined because %q, want too many requeues", reason)
+	}
+	// Three requeues, then the fourth failure quarantines it.
+	if n := len(handled); n != 4 {
+		t.Errorf("handled %d times, want 4", n)
+	}
+	if n := sub.PoisonStats().Quarantined.Load(); n != 1 {
+		t.Errorf("%d quarantined, want 1", n)
+	}
+	if n := counted.Value() - before; n != 1 {
+		t.Errorf("%v quarantines counted, want 1", n)
+	}
+}
+
+func TestPoisonCallbackDecides(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	const queue = "poison_callback_test"
+	acks := settledTotal.With(queue, "ack")
+	before := acks.Value()
+	type call struct {
+		body string
+		env  Envelope
+		err  error
+	}
+	calls := make(chan call, 1)
+	sub := subscribePoison(t, conn, queue, PoisonCallback(func(body []byte, env Envelope, err error) ActType {
+		calls <- call{string(body), env, err}
+		return Ack
+	}), func(string) ActType { return Ack })
+
+	publishRaw(t, ch, routing.ArmyMovesKey("poison"), "{")
+	select {
+	case c := <-calls:
+		if c.body != "{" || c.err == nil {
+			t.Errorf("called with %q and %v, want the raw body and the decode error", c.body, c.err)
+		}
+		if c.env.Queue != queue || c.env.RoutingKey != routing.ArmyMovesKey("poison").String() {
+			t.Errorf("envelope %+v, want the queue and routing key", c.env)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("callback never ran")
+	}
+	// The callback's act settles the delivery.
+	deadline := time.Now().Add(2 * time.Second)
+	for acks.Value()-before != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("delivery never acked")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := sub.PoisonStats().Callbacks.Load(); n != 1 {
+		t.Errorf("%d callbacks counted, want 1", n)
+	}
+}
@@ -1,10 +1,7 @@
 package pubsub
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -12,71 +9,75 @@ import (
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	jsonData, err := json.Marshal(val)
-	if err != nil {
-		log.Printf("Could not marshall value err: %s \n", err)
-		return err
-	}
+func PublishJSON[T any](ch Publisher, exchange string, key routing.Key, val T) error {
+	return PublishJSONWithContext(context.Background(), ch, exchange, key, val)
+}
 
-	if err := ch.PublishWithContext(
-		context.Background(),
-		exchange,
-		key,
-		false,
-		false,
-		amqp.Publishing{ContentType: "application/json", Body: jsonData},
-	); err != nil {
+func PublishJSONWithContext[T any](ctx context.Context, ch Publisher, exchange string, key routing.Key, val T) error {
+	return Publish(ctx, ch, JSON, exchange, key, val)
+}
 
-		log.Printf("Could not publish err: %s \n", err)
-		return err
-	}
+func PublishGob[T any](ch Publisher, exchange string, key routing.Key, val T) error {
+	return PublishGobWithContext(context.Background(), ch, exchange, key, val)
+}
 
-	return nil
+func PublishGobWithContext[T any](ctx context.Context, ch Publisher, exchange string, key routing.Key, val T) error {
+	return Publish(ctx, ch, Gob, exchange, key, val)
 }
 
-func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	gobData, err := EncodeToGob(val)
+// Publish encodes val with codec and publishes it under the codec's content
+// type, so subscribers can pick the matching decoder.
+func Publish[T any](ctx context.Context, ch Publisher, codec Codec, exchange string, key routing.Key, val T) error {
+	ctx, span := tracing.Start(ctx, "publish "+exchange, tracing.KindProducer,
+		"messaging.destination", exchange,
+		"messaging.routing_key", key.String(),
+	)
+	defer span.End()
+
+	data, err := codec.Marshal(val)
 	if err != nil {
 		log.Printf("Could not marshall value err: %s \n", err)
+		span.SetError(err)
 		return err
 	}
 
 	if err := ch.PublishWithContext(
-		context.Background(),
+		ctx,
 		exchange,
-		key,
+		key.String(),
 		false,
 		false,
-		amqp.Publishing{ContentType: "application/gob", Body: gobData},
+		stamp(ctx, amqp.Publishing{ContentType: codec.ContentType(), Body: data}),
 	); err != nil {
 
-		log.Printf("Could not publish gob -> %s \n", err)
+		log.Printf("Could not publish err: %s \n", err)
+		publishFailures.With(exchange, key.String()).Inc()
+		span.SetError(err)
 		return err
 	}
 
+	publishedTotal.With(exchange, key.String()).Inc()
 	return nil
 }
 
 func EncodeToGob(data any) ([]byte, error) {
-	var buffer bytes.Buffer
-	encoder := gob.NewEncoder(&buffer)
+	return Gob.Marshal(data)
+}
 
-	err := encoder.Encode(data)
-	if err != nil {
-		return []byte{}, err
-	}
-	return buffer.Bytes(), err
+func PublishGameLog(ch Publisher, username, msg string) error {
+	return PublishGameLogWithContext(context.Background(), ch, username, msg)
 }
 
-func PublishGameLog(ch *amqp.Channel, username, msg string) error {
-	return PublishGob(
+func PublishGameLogWithContext(ctx context.Context, ch Publisher, username, msg string) error {
+	return PublishGobWithContext(
+		ctx,
 		ch,
 		routing.ExchangePerilTopic,
-		routing.GameLogSlug+"."+username,
+		routing.GameLogKey(username),
 		routing.GameLog{
 			Username:    username,
 			CurrentTime: time.Now(),
@@ -93,16 +94,19 @@ const (
 )
 
 func DeclareAndBind(
-	conn *amqp.Connection,
+	conn Transport,
 	exchange,
-	queueName,
-	key string,
+	queueName string,
+	key routing.Pattern,
 	queueType SimpleQueueType,
-) (*amqp.Channel, amqp.Queue, error) {
+) (Channel, amqp.Queue, error) {
 	ch, err := conn.Channel()
 	if err != nil {
 		return nil, amqp.Queue{}, err
 	}
+	if err := DeclareDeadLetter(ch); err != nil {
+		return nil, amqp.Queue{}, err
+	}
 
 	var durable bool
 	var autoDelete bool
@@ -124,12 +128,12 @@ func DeclareAndBind(
 		autoDelete,
 		exclusive,
 		false,
-		amqp.Table{"x-dead-letter-exchange": "peril_dlx"},
+		amqp.Table(routing.DeadLetterArgs()),
 	)
 	if err != nil {
 		return nil, amqp.Queue{}, err
 	}
-	if err = ch.QueueBind(queueName, key, exchange, false, nil); err != nil {
+	if err = ch.QueueBind(queueName, string(key), exchange, false, nil); err != nil {
 		return nil, amqp.Queue{}, err
 	}
 	return ch, queue, nil
@@ -141,17 +145,28 @@ const (
 	Ack ActType = iota
 	NackRequeue
 	NackDiscard
+	// RetryLater redelivers the message after a delay when the subscription
+	// has a RetryPolicy, and behaves like NackRequeue when it doesn't.
+	RetryLater
 )
 
 func subscribe[T any](
-	conn *amqp.Connection,
+	ctx context.Context,
+	conn Transport,
 	exchange,
-	queueName,
-	key string,
+	queueName string,
+	key routing.Pattern,
 	queueType SimpleQueueType,
-	handler func(T) ActType,
-	unmarshaller func([]byte) (T, error),
-) error {
+	handler func(T, Envelope) ActType,
+	unmarshaller func(contentType string, body []byte) (T, error),
+	opts []SubscribeOption,
+) (*Subscription, error) {
+	options := newSubscribeOptions(opts)
+	if options.retry != nil {
+		if err := options.retry.validate(); err != nil {
+			return nil, err
+		}
+	}
 	ch, queue, err := DeclareAndBind(
 		conn,
 		exchange,
@@ -160,14 +175,28 @@ func subscribe[T any](
 		queueType,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err := ch.Qos(10, 0, false); err != nil {
-		return err
+	if options.retry != nil {
+		if err := declareRetryQueues(ch, queue.Name, queueType, *options.retry); err != nil {
+			ch.Close()
+			return nil, err
+		}
+	}
+	if options.poison.action == poisonQuarantine {
+		if err := declareQuarantine(ch, queue.Name, queueType); err != nil {
+			ch.Close()
+			return nil, err
+		}
+	}
+	if err := ch.Qos(options.prefetch, 0, false); err != nil {
+		ch.Close()
+		return nil, err
 	}
+	tag := newConsumerTag()
 	chDelivery, err := ch.Consume(
 		queue.Name,
-		"",
+		tag,
 		false,
 		false,
 		false,
@@ -175,67 +204,202 @@ func subscribe[T any](
 		amqp.Table{},
 	)
 	if err != nil {
-		return err
+		ch.Close()
+		return nil, err
 	}
 
-	go func() {
-		for item := range chDelivery {
-			message, err := unmarshaller(item.Body)
-			if err != nil {
-				fmt.Printf("could not unmarshal message %v\n", err)
-				continue
+	sub := newSubscription(ch, tag, queue.Name)
+	context.AfterFunc(ctx, func() {
+		sub.Stop(context.Background())
+	})
+	go watchDepth(conn, sub, queue.Messages, queueDepthInterval)
+	wrapped := Chain(options.chain...)(func(message any, env Envelope) ActType {
+		return handler(message.(T), env)
+	})
+	// ack and nack settle a delivery and count how it was settled, whatever
+	// decided it.
+	ack := func(item amqp.Delivery) {
+		item.Ack(false)
+		settledTotal.With(queue.Name, "ack").Inc()
+	}
+	nack := func(item amqp.Delivery, requeue bool) {
+		item.Nack(false, requeue)
+		if requeue {
+			settledTotal.With(queue.Name, "requeue").Inc()
+		} else {
+			settledTotal.With(queue.Name, "discard").Inc()
+		}
+	}
+	var requeues requeueCounts
+	var settle func(item amqp.Delivery, actType ActType)
+	// poison deals with a delivery by the poison policy: one that can't be
+	// decoded, or that the handler has given back too many times.
+	poison := func(item amqp.Delivery, err error) {
+		switch options.poison.action {
+		case poisonQuarantine:
+			if err := quarantine(ch, item, queue.Name, err); err != nil {
+				log.Printf("Could not quarantine message -> %v \n", err)
+				nack(item, true)
+				return
+			}
+			sub.poison.Quarantined.Add(1)
+			poisonTotal.With(queue.Name, "quarantine").Inc()
+			ack(item)
+		case poisonCallback:
+			sub.poison.Callbacks.Add(1)
+			poisonTotal.With(queue.Name, "callback").Inc()
+			env := envelopeFrom(item)
+			env.Queue = queue.Name
+			settle(item, options.poison.callback(item.Body, env, err))
+		default:
+			sub.poison.Discarded.Add(1)
+			poisonTotal.With(queue.Name, "discard").Inc()
+			nack(item, false)
+		}
+	}
+	settle = func(item amqp.Delivery, actType ActType) {
+		if limit := options.poison.maxRequeues; limit > 0 {
+			if actType != NackRequeue {
+				requeues.forget(item.MessageId)
+			} else if requeues.add(item.MessageId) > limit {
+				requeues.forget(item.MessageId)
+				poison(item, fmt.Errorf("%w: %d times", ErrTooManyRequeues, limit))
+				return
+			}
+		}
+		switch actType {
+		case Ack:
+			ack(item)
+		case NackDiscard:
+			nack(item, false)
+		case NackRequeue:
+			nack(item, true)
+		case RetryLater:
+			if options.retry == nil {
+				nack(item, true)
+				return
+			}
+			if err := retry(ch, item, queue.Name, *options.retry); err != nil {
+				log.Printf("Could not schedule retry -> %v \n", err)
+				nack(item, true)
+				return
 			}
-			actType := handler(message)
-			switch actType {
-			case Ack:
-				fmt.Println("Handler acknoledged")
-				item.Ack(false)
-			case NackDiscard:
-				fmt.Println("Handler Not acknoledged and discarded")
-				item.Nack(false, false)
-			case NackRequeue:
-				fmt.Println("Handler Not acknoledged and requeued")
-				item.Nack(false, true)
-			default:
-				fmt.Printf("Could not infer act type -> %v \n", actType)
+			if deliveryAttempt(item.Headers) >= options.retry.MaxAttempts {
+				retriesParked.With(queue.Name).Inc()
 			}
+			ack(item)
+		default:
+			// Left unsettled it would hold a prefetch slot for good.
+			fmt.Printf("Could not infer act type -> %v \n", actType)
+			nack(item, false)
+		}
+	}
+	handle := func(item amqp.Delivery) {
+		deliveriesTotal.With(queue.Name).Inc()
+		inflight.With(queue.Name).Inc()
+		defer inflight.With(queue.Name).Dec()
+
+		message, err := unmarshaller(item.ContentType, item.Body)
+		if err != nil {
+			log.Printf("Could not decode message %s from %s -> %v \n", item.MessageId, queue.Name, err)
+			if options.undecodable != nil {
+				env := envelopeFrom(item)
+				env.Queue = queue.Name
+				options.undecodable(env, err)
+			}
+			poison(item, err)
+			return
+		}
+		env := envelopeFrom(item)
+		env.Queue = queue.Name
+		spanCtx := context.Background()
+		if parent, ok := remoteSpan(item.Headers); ok {
+			spanCtx = tracing.ContextWithRemoteParent(spanCtx, parent)
 		}
+		spanCtx, span := tracing.Start(spanCtx, "process "+queue.Name, tracing.KindConsumer,
+			"messaging.source", queue.Name,
+			"messaging.routing_key", env.RoutingKey,
+			"messaging.message_id", env.MessageID,
+		)
+		defer span.End()
+		env.ctx = spanCtx
+
+		start := time.Now()
+		actType := wrapped(message, env)
+		handlerDuration.With(queue.Name).ObserveDuration(time.Since(start))
+		span.SetAttribute("peril.act", actType.String())
+		settle(item, actType)
+	}
+	go func() {
+		defer close(sub.done)
+		fanOut(chDelivery, options.workers, options.prefetch, options.ordered, handle)
+		log.Printf("Stopped consuming from %s \n", queue.Name)
 	}()
 
-	return nil
+	return sub, nil
 }
 
 func HandlerPause(gs *gamelogic.GameState) func(routing.PlayingState) ActType {
 	return func(ps routing.PlayingState) ActType {
-		defer fmt.Print("> ")
 		gs.HandlePause(ps)
 		return Ack
 	}
 }
 
-func HandlerMove(gs *gamelogic.GameState, publishCh *amqp.Channel) func(gamelogic.ArmyMove) ActType {
-	return func(am gamelogic.ArmyMove) ActType {
-		defer fmt.Print("> ")
+func HandlerMap(gs *gamelogic.GameState) func(gamelogic.MapDefinition) ActType {
+	return func(def gamelogic.MapDefinition) ActType {
+		if err := gs.HandleMap(def); err != nil {
+			return NackDiscard
+		}
+		return Ack
+	}
+}
+
+// HandlerMapRequest answers clients asking for the map, so players who join
+// after it was broadcast still get it.
+func HandlerMapRequest(world *gamelogic.WorldMap) func(routing.MapRequest, Envelope) (gamelogic.MapDefinition, ActType) {
+	return func(req routing.MapRequest, env Envelope) (gamelogic.MapDefinition, ActType) {
+		return world.Definition(), Ack
+	}
+}
+
+func HandlerMove(gs *gamelogic.GameState, publishCh Publisher) func(gamelogic.ArmyMove, Envelope) ActType {
+	return func(am gamelogic.ArmyMove, env Envelope) ActType {
+		// A move can only come from the player whose key it was sent on.
+		mover, err := routing.ParseArmyMovesKey(env.RoutingKey)
+		if err != nil || mover != am.Player.Username {
+			fmt.Printf("Rejecting move from %q sent on %q \n", am.Player.Username, env.RoutingKey)
+			return NackDiscard
+		}
 		outcome := gs.HandleMove(am)
 		switch outcome {
 		case gamelogic.MoveOutComeSafe:
 			return Ack
 		case gamelogic.MoveOutcomeMakeWar:
-			err := PublishJSON(
+			err := PublishJSONWithContext(
+				replyContext(gs, env),
 				publishCh,
 				routing.ExchangePerilTopic,
-				routing.WarRecognitionsPrefix+"."+gs.GetUsername(),
+				routing.WarKey(am.Player.Username),
 				gamelogic.RecognitionOfWar{
 					Attacker: am.Player,
 					Defender: gs.GetPlayerSnap(),
 				})
+			// publishCh is the outbox, which takes the declaration as soon
+			// as it is on disk. If the attacker has no war queue, the relay
+			// parks it later.
 			if err != nil {
 				fmt.Printf("Could not process the request -> %v \n", err)
 				return NackRequeue
 			}
 			return Ack
 		case gamelogic.MoveOutcomeSamePlayer:
-			return NackDiscard
+			// Our own move, already applied when it was made.
+			return Ack
+		case gamelogic.MoveOutcomeIllegal:
+			// Every player gets a copy and ignores it the same way, so
+			// dead-lettering each would fill peril_dlq with duplicates.
+			return Ack
 		default:
 			return NackDiscard
 		}
@@ -244,118 +408,221 @@ func HandlerMove(gs *gamelogic.GameState, publishCh *amqp.Channel) func(gamelogi
 
 func HandlerLogs() func(routing.GameLog) ActType {
 	return func(gl routing.GameLog) ActType {
-		defer fmt.Print("> ")
 		err := gamelogic.WriteLog(gl)
 		if err != nil {
-			return NackRequeue
+			return RetryLater
 		}
 		return Ack
 	}
 }
 
-func HandlerWar(gs *gamelogic.GameState, publishCh *amqp.Channel) func(gamelogic.RecognitionOfWar) ActType {
-	return func(rw gamelogic.RecognitionOfWar) ActType {
-		defer fmt.Print("> ")
-		warOutcome, winner, loser := gs.HandleWar(rw)
+func HandlerWar(gs *gamelogic.GameState, publishCh Publisher) func(gamelogic.RecognitionOfWar, Envelope) ActType {
+	return func(rw gamelogic.RecognitionOfWar, env Envelope) ActType {
+		if gs.WarSettled(env.MessageID) {
+			return Ack
+		}
+		warOutcome, result := gs.HandleWar(rw)
 		switch warOutcome {
 		case gamelogic.WarOutcomeNotInvolved:
-			return NackRequeue
+			// Wars are routed to the attacker's own queue, so this one was
+			// misaddressed and no other player will want it either.
+			return NackDiscard
 		case gamelogic.WarOutcomeNoUnits:
 			return NackDiscard
-		case gamelogic.WarOutcomeYouWon:
-			err := PublishGameLog(
-				publishCh,
-				gs.GetUsername(),
-				fmt.Sprintf("%s won a war against %s \n", winner, loser),
-			)
-			if err != nil {
-				return NackRequeue
-			}
-			return Ack
-		case gamelogic.WarOutcomeOpponentWon:
-			err := PublishGameLog(
-				publishCh,
-				gs.GetUsername(),
-				fmt.Sprintf("%s won a war against %s \n", winner, loser),
-			)
+		}
 
-			if err != nil {
-				return NackRequeue
-			}
-			return Ack
-		case gamelogic.WarOutcomeDraw:
-			err := PublishGameLog(
-				publishCh,
-				gs.GetUsername(),
-				fmt.Sprintf("A war between %s and %s resulted in a draw \n", winner, loser),
-			)
-			if err != nil {
-				return NackRequeue
-			}
-			return Ack
-		default:
-			fmt.Println("Invalid war outcome!")
+		// The defender's losses come from this result, so it has to be on
+		// its way before the attacker applies theirs.
+		ctx := replyContext(gs, env)
+		result.WarID = env.MessageID
+		err := PublishJSONWithContext(ctx, publishCh, routing.ExchangePerilTopic, routing.WarResultKey(result.Defender), result)
+		if err != nil {
+			fmt.Printf("Could not send the war result to %s -> %v \n", result.Defender, err)
+			return NackRequeue
+		}
+		gs.ApplyWarResult(result)
+
+		msg := fmt.Sprintf("%s won a war against %s \n", result.Winner, result.Loser())
+		if result.Winner == "" {
+			msg = fmt.Sprintf("A war between %s and %s resulted in a draw \n", result.Attacker, result.Defender)
+		}
+		if err := PublishGameLogWithContext(ctx, publishCh, gs.GetUsername(), msg); err != nil {
+			// The war is settled either way; requeueing would only skip it.
+			fmt.Printf("Could not log the war -> %v \n", err)
+		}
+		return Ack
+	}
+}
+
+// HandlerWarResult applies the result of a war the attacker resolved to the
+// defender's units.
+func HandlerWarResult(gs *gamelogic.GameState) func(gamelogic.WarResult, Envelope) ActType {
+	return func(wr gamelogic.WarResult, env Envelope) ActType {
+		// Only results addressed to this player, about a war against them,
+		// count.
+		defender, err := routing.ParseWarResultKey(env.RoutingKey)
+		if err != nil || defender != wr.Defender || defender != gs.GetUsername() {
+			fmt.Printf("Rejecting war result for %q sent on %q \n", wr.Defender, env.RoutingKey)
 			return NackDiscard
 		}
+		gs.ApplyWarResult(wr)
+		return Ack
 	}
+}
 
+// replyContext is the context for messages a handler publishes in response
+// to env: sent by this player and part of the same correlation chain and
+// trace.
+func replyContext(gs *gamelogic.GameState, env Envelope) context.Context {
+	return env.Correlate(ContextWithSender(env.Context(), gs.GetUsername()))
 }
 
 func SubscribeJSON[T any](
-	conn *amqp.Connection,
+	conn Transport,
 	exchange,
-	queueName,
-	key string,
+	queueName string,
+	key routing.Pattern,
 	queueType SimpleQueueType,
 	handler func(T) ActType,
+	opts ...SubscribeOption,
 ) error {
-	return subscribe(
+	_, err := SubscribeJSONWithContext(
+		context.Background(),
 		conn,
 		exchange,
 		queueName,
 		key,
 		queueType,
 		handler,
-		unmarshallJson,
+		opts...,
+	)
+	return err
+}
+
+// SubscribeJSONWithContext is SubscribeJSON with a handle to stop the
+// consumer. It is also stopped, and drained, when ctx is cancelled.
+func SubscribeJSONWithContext[T any](
+	ctx context.Context,
+	conn Transport,
+	exchange,
+	queueName string,
+	key routing.Pattern,
+	queueType SimpleQueueType,
+	handler func(T) ActType,
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	return subscribe(
+		ctx,
+		conn,
+		exchange,
+		queueName,
+		key,
+		queueType,
+		ignoreEnvelope(handler),
+		decoderFor[T](JSON),
+		opts,
 	)
 }
 
 func SubscribeGob[T any](
-	conn *amqp.Connection,
+	conn Transport,
 	exchange,
-	queueName,
-	key string,
+	queueName string,
+	key routing.Pattern,
 	queueType SimpleQueueType,
 	handler func(T) ActType,
+	opts ...SubscribeOption,
 ) error {
-	return subscribe(
+	_, err := SubscribeGobWithContext(
+		context.Background(),
 		conn,
 		exchange,
 		queueName,
 		key,
 		queueType,
 		handler,
-		unmarshallGob,
+		opts...,
 	)
+	return err
 }
 
-func unmarshallJson[T any](data []byte) (T, error) {
-	var message T
-	if err := json.Unmarshal(data, &message); err != nil {
-		fmt.Printf("could not unmarshal message: %v\n", err)
-		return message, err
-	}
-	return message, nil
+// SubscribeGobWithContext is SubscribeGob with a handle to stop the
+// consumer. It is also stopped, and drained, when ctx is cancelled.
+func SubscribeGobWithContext[T any](
+	ctx context.Context,
+	conn Transport,
+	exchange,
+	queueName string,
+	key routing.Pattern,
+	queueType SimpleQueueType,
+	handler func(T) ActType,
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	return subscribe(
+		ctx,
+		conn,
+		exchange,
+		queueName,
+		key,
+		queueType,
+		ignoreEnvelope(handler),
+		decoderFor[T](Gob),
+		opts,
+	)
+}
+
+// Subscribe consumes messages in any registered format, choosing the codec
+// from each delivery's content type. Deliveries without one are read as JSON.
+func Subscribe[T any](
+	ctx context.Context,
+	conn Transport,
+	exchange,
+	queueName string,
+	key routing.Pattern,
+	queueType SimpleQueueType,
+	handler func(T) ActType,
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	return subscribe(
+		ctx,
+		conn,
+		exchange,
+		queueName,
+		key,
+		queueType,
+		ignoreEnvelope(handler),
+		decoderFor[T](JSON),
+		opts,
+	)
 }
 
-func unmarshallGob[T any](data []byte) (T, error) {
-	decoder := gob.NewDecoder(bytes.NewBuffer(data))
+// SubscribeEnvelope is Subscribe for handlers that also want the message's
+// envelope: its ID, sender, timestamp, correlation ID and so on.
+func SubscribeEnvelope[T any](
+	ctx context.Context,
+	conn Transport,
+	exchange,
+	queueName string,
+	key routing.Pattern,
+	queueType SimpleQueueType,
+	handler func(T, Envelope) ActType,
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	return subscribe(
+		ctx,
+		conn,
+		exchange,
+		queueName,
+		key,
+		queueType,
+		handler,
+		decoderFor[T](JSON),
+		opts,
+	)
+}
 
-	var message T
-	err := decoder.Decode(&message)
-	if err != nil {
-		return message, err
+func ignoreEnvelope[T any](handler func(T) ActType) func(T, Envelope) ActType {
+	return func(message T, _ Envelope) ActType {
+		return handler(message)
 	}
-
-	return message, nil
 }
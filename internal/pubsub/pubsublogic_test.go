@@ -0,0 +1,290 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// perilBroker starts a MemoryBroker with Peril's topology declared on it.
+func perilBroker(t *testing.T) (*MemoryBroker, Transport, Channel) {
+	t.Helper()
+	b := NewMemoryBroker()
+	conn := b.Connect()
+	t.Cleanup(func() { conn.Close() })
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyTopology(ch, routing.PerilTopology()); err != nil {
+		t.Fatal(err)
+	}
+	return b, conn, ch
+}
+
+// recordActs is middleware that reports every decision a handler makes.
+func recordActs(acts chan<- ActType) Middleware {
+	return func(next Handler) Handler {
+		return func(message any, env Envelope) ActType {
+			act := next(message, env)
+			acts <- act
+			return act
+		}
+	}
+}
+
+func nextAct(t *testing.T, acts <-chan ActType) ActType {
+	t.Helper()
+	select {
+	case act := <-acts:
+		return act
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler never ran")
+		return 0
+	}
+}
+
+// waitGet waits for a message on queue and decodes it as JSON into v.
+func waitGet(t *testing.T, ch Channel, queue string, v any) Envelope {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		d, ok, err := ch.Get(queue, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			if err := json.Unmarshal(d.Body, v); err != nil {
+				t.Fatal(err)
+			}
+			return envelopeFrom(d)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("nothing arrived on %s", queue)
+	return Envelope{}
+}
+
+func queueLength(t *testing.T, ch Channel, queue string) int {
+	t.Helper()
+	q, err := ch.QueueDeclare(queue, true, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q.Messages
+}
+
+// subscribeMoves runs HandlerMove for gs and returns what it decides.
+func subscribeMoves(t *testing.T, conn Transport, gs *gamelogic.GameState, pub Publisher) <-chan ActType {
+	t.Helper()
+	acts := make(chan ActType, 10)
+	sub, err := SubscribeEnvelope(
+		context.Background(),
+		conn,
+		routing.ExchangePerilTopic,
+		routing.ArmyMovesQueue(gs.GetUsername()),
+		routing.ArmyMovesPattern,
+		Transient,
+		HandlerMove(gs, pub),
+		WithMiddleware(recordActs(acts)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Stop(context.Background()) })
+	return acts
+}
+
+// spawn gives gs a unit, failing the test if it can't.
+func spawn(t *testing.T, gs *gamelogic.GameState, loc, rank string) {
+	t.Helper()
+	if err := gs.CommandSpawn([]string{"spawn", loc, rank}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// moveOf is username moving units from one location to another.
+func moveOf(username string, from, to gamelogic.Location, units ...gamelogic.Unit) gamelogic.ArmyMove {
+	player := gamelogic.Player{Username: username, Units: map[int]gamelogic.Unit{}}
+	for i := range units {
+		units[i].Location = to
+		player.Units[units[i].ID] = units[i]
+	}
+	return gamelogic.ArmyMove{Player: player, Units: units, FromLocation: from, ToLocation: to}
+}
+
+func publishMove(t *testing.T, ch Channel, key routing.Key, mv gamelogic.ArmyMove) {
+	t.Helper()
+	ctx := ContextWithSender(context.Background(), mv.Player.Username)
+	if err := PublishJSONWithContext(ctx, ch, routing.ExchangePerilTopic, key, mv); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandlerMoveSafe(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	bob := gamelogic.NewGameState("bob")
+	spawn(t, bob, "australia", "infantry")
+	acts := subscribeMoves(t, conn, bob, ch)
+
+	publishMove(t, ch, routing.ArmyMovesKey("alice"), moveOf("alice", "europe", "asia", gamelogic.Unit{ID: 1, Rank: "infantry"}))
+
+	if act := nextAct(t, acts); act != Ack {
+		t.Errorf("got %v, want ack", act)
+	}
+}
+
+func TestHandlerMoveDeclaresWarOnAttacker(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	if _, _, err := DeclareAndBind(conn, routing.ExchangePerilTopic, routing.WarQueue("alice"), routing.WarKey("alice").Pattern(), Durable); err != nil {
+		t.Fatal(err)
+	}
+	bob := gamelogic.NewGameState("bob")
+	spawn(t, bob, "asia", "infantry")
+	acts := subscribeMoves(t, conn, bob, ch)
+
+	publishMove(t, ch, routing.ArmyMovesKey("alice"), moveOf("alice", "europe", "asia", gamelogic.Unit{ID: 1, Rank: "cavalry"}))
+
+	if act := nextAct(t, acts); act != Ack {
+		t.Errorf("got %v, want ack", act)
+	}
+	var rw gamelogic.RecognitionOfWar
+	env := waitGet(t, ch, routing.WarQueue("alice"), &rw)
+	if rw.Attacker.Username != "alice" || rw.Defender.Username != "bob" || len(rw.Defender.Units) != 1 {
+		t.Errorf("got %+v, want alice attacking bob's one unit", rw)
+	}
+	if env.Sender != "bob" {
+		t.Errorf("war sent by %q, want bob", env.Sender)
+	}
+}
+
+func TestHandlerMoveRejectsSpoofedMover(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	bob := gamelogic.NewGameState("bob")
+	acts := subscribeMoves(t, conn, bob, ch)
+
+	publishMove(t, ch, routing.ArmyMovesKey("mallory"), moveOf("alice", "europe", "asia", gamelogic.Unit{ID: 1, Rank: "infantry"}))
+
+	if act := nextAct(t, acts); act != NackDiscard {
+		t.Errorf("got %v, want discard", act)
+	}
+	var mv gamelogic.ArmyMove
+	waitGet(t, ch, routing.QueuePerilDLQ, &mv)
+	if mv.Player.Username != "alice" {
+		t.Errorf("dead-lettered %+v, want alice's move", mv)
+	}
+}
+
+func TestHandlerMoveAcksOwnAndIllegalMoves(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	bob := gamelogic.NewGameState("bob")
+	spawn(t, bob, "asia", "infantry")
+	acts := subscribeMoves(t, conn, bob, ch)
+
+	tests := []struct {
+		name string
+		key  routing.Key
+		move gamelogic.ArmyMove
+	}{
+		{"own move", routing.ArmyMovesKey("bob"), moveOf("bob", "asia", "europe", gamelogic.Unit{ID: 1, Rank: "infantry"})},
+		{"too far", routing.ArmyMovesKey("alice"), moveOf("alice", "europe", "australia", gamelogic.Unit{ID: 1, Rank: "infantry"})},
+		{"off the map", routing.ArmyMovesKey("alice"), moveOf("alice", "europe", "atlantis", gamelogic.Unit{ID: 1, Rank: "infantry"})},
+	}
+	for _, tt := range tests {
+		publishMove(t, ch, tt.key, tt.move)
+		if act := nextAct(t, acts); act != Ack {
+			t.Errorf("%s: got %v, want ack", tt.name, act)
+		}
+	}
+	if n := queueLength(t, ch, routing.QueuePerilDLQ); n != 0 {
+		t.Errorf("%d messages dead-lettered, want none", n)
+	}
+}
+
+// publishAs publishes v as JSON with a fixed message ID, the way the broker
+// would hand the same message over again.
+func publishAs(t *testing.T, ch Channel, key routing.Key, id string, v any) {
+	t.Helper()
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := amqp.Publishing{ContentType: JSON.ContentType(), MessageId: id, Body: body}
+	if err := ch.PublishWithContext(context.Background(), routing.ExchangePerilTopic, key.String(), false, false, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWarAppliedOnceOnBothSides(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	alice, bob := gamelogic.NewGameState("alice"), gamelogic.NewGameState("bob")
+	spawn(t, alice, "asia", "cavalry")
+	spawn(t, alice, "europe", "infantry")
+	spawn(t, bob, "asia", "cavalry")
+	spawn(t, bob, "australia", "infantry")
+
+	aliceActs, bobActs := make(chan ActType, 10), make(chan ActType, 10)
+	wars, err := SubscribeEnvelope(context.Background(), conn, routing.ExchangePerilTopic,
+		routing.WarQueue("alice"), routing.WarKey("alice").Pattern(), Durable,
+		HandlerWar(alice, ch), WithMiddleware(recordActs(aliceActs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wars.Stop(context.Background())
+	results, err := SubscribeEnvelope(context.Background(), conn, routing.ExchangePerilTopic,
+		routing.WarResultQueue("bob"), routing.WarResultKey("bob").Pattern(), Durable,
+		HandlerWarResult(bob), WithMiddleware(recordActs(bobActs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer results.Stop(context.Background())
+
+	// They only meet in asia, where equal cavalry draws, so each side loses
+	// its unit there and keeps the other.
+	war := gamelogic.RecognitionOfWar{Attacker: alice.GetPlayerSnap(), Defender: bob.GetPlayerSnap()}
+	publishAs(t, ch, routing.WarKey("alice"), "war-1", war)
+	if act := nextAct(t, aliceActs); act != Ack {
+		t.Fatalf("attacker: got %v, want ack", act)
+	}
+	if act := nextAct(t, bobActs); act != Ack {
+		t.Fatalf("defender: got %v, want ack", act)
+	}
+	for _, gs := range []*gamelogic.GameState{alice, bob} {
+		if _, ok := gs.GetUnit(1); ok {
+			t.Errorf("%s's cavalry survived", gs.GetUsername())
+		}
+		if _, ok := gs.GetUnit(2); !ok {
+			t.Errorf("%s's infantry died", gs.GetUsername())
+		}
+	}
+
+	// Put a unit 1 back in asia on both sides, so applying the war again
+	// would kill it, then deliver the war and its result a second time.
+	for _, gs := range []*gamelogic.GameState{alice, bob} {
+		gs.UpdateUnit(gamelogic.Unit{ID: 1, Rank: "cavalry", Location: "asia"})
+	}
+	publishAs(t, ch, routing.WarKey("alice"), "war-1", war)
+	if act := nextAct(t, aliceActs); act != Ack {
+		t.Errorf("redelivered war: got %v, want ack", act)
+	}
+	publishAs(t, ch, routing.WarResultKey("bob"), "result-1", gamelogic.WarResult{
+		WarID:          "war-1",
+		Attacker:       "alice",
+		Defender:       "bob",
+		Location:       "asia",
+		DefenderLosses: []gamelogic.Unit{{ID: 1, Rank: "cavalry", Location: "asia"}},
+	})
+	if act := nextAct(t, bobActs); act != Ack {
+		t.Errorf("redelivered result: got %v, want ack", act)
+	}
+	for _, gs := range []*gamelogic.GameState{alice, bob} {
+		if _, ok := gs.GetUnit(1); !ok {
+			t.Errorf("the war was applied to %s twice", gs.GetUsername())
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	HeaderAttempts           = "x-peril-attempts"
+	HeaderOriginalExchange   = "x-peril-original-exchange"
+	HeaderOriginalRoutingKey = "x-peril-original-routing-key"
+)
+
+// RetryPolicy says how a subscription retries messages its handler returns
+// RetryLater for. Each retry waits in a queue named <queue>.retry.<ms> until
+// its TTL runs out and the broker dead-letters it back to <queue>. Once a
+// message has been delivered MaxAttempts times it is moved to <queue>.parking
+// instead, for someone to look at.
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	Multiplier   float64
+	MaxDelay     time.Duration
+}
+
+// DefaultRetryPolicy waits 1s, 2s, 4s and 8s between five attempts.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: time.Second,
+	Multiplier:   2,
+	MaxDelay:     time.Minute,
+}
+
+// validate rejects policies that could not retry anything: a message needs a
+// delay to wait in a retry queue, and a second attempt to be retried at all.
+func (p RetryPolicy) validate() error {
+	if p.InitialDelay <= 0 {
+		return fmt.Errorf("retry policy: initial delay %v is not positive", p.InitialDelay)
+	}
+	if p.MaxAttempts <= 1 {
+		return fmt.Errorf("retry policy: %d max attempts leaves nothing to retry", p.MaxAttempts)
+	}
+	return nil
+}
+
+// Delay is how long to wait before redelivering after the given attempt,
+// counting from 1. It is rounded down to the millisecond, the resolution of
+// queue TTLs.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := float64(p.InitialDelay)
+	for i := 1; i < attempt; i++ {
+		delay *= max(p.Multiplier, 1)
+		if p.MaxDelay > 0 && delay >= float64(p.MaxDelay) {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	return time.Duration(delay).Truncate(time.Millisecond)
+}
+
+// RetryQueueName is the queue that holds messages from queue for delay.
+func RetryQueueName(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%d", queue, delay.Milliseconds())
+}
+
+// ParkingQueueName is where messages from queue go once they run out of
+// attempts.
+func ParkingQueueName(queue string) string {
+	return queue + ".parking"
+}
+
+// declareRetryQueues declares the retry queues policy needs for queue, plus
+// its parking queue. They live as long as queue does: durable for a durable
+// queue, exclusive to the connection for a transient one.
+func declareRetryQueues(ch Channel, queue string, queueType SimpleQueueType, policy RetryPolicy) error {
+	durable := queueType == Durable
+	exclusive := queueType == Transient
+	declared := map[time.Duration]bool{}
+	for attempt := 1; attempt < policy.MaxAttempts; attempt++ {
+		delay := policy.Delay(attempt)
+		if declared[delay] {
+			continue
+		}
+		declared[delay] = true
+		_, err := ch.QueueDeclare(
+			RetryQueueName(queue, delay),
+			durable,
+			false,
+			exclusive,
+			false,
+			amqp.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": queue,
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := ch.QueueDeclare(ParkingQueueName(queue), durable, false, exclusive, false, nil)
+	return err
+}
+
+// retry republishes d to the retry queue for its next attempt, or to the
+// parking queue if it has none left. The caller acks d once this succeeds.
+func retry(ch Publisher, d amqp.Delivery, queue string, policy RetryPolicy) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	// Retried messages come back through the default exchange, so keep
+	// where they were first sent.
+	if _, ok := headers[HeaderOriginalExchange]; !ok {
+		headers[HeaderOriginalExchange] = d.Exchange
+		headers[HeaderOriginalRoutingKey] = d.RoutingKey
+	}
+
+	attempt := deliveryAttempt(d.Headers)
+	target := ParkingQueueName(queue)
+	if attempt < policy.MaxAttempts {
+		target = RetryQueueName(queue, policy.Delay(attempt))
+		headers[HeaderAttempts] = int32(attempt + 1)
+	}
+
+	msg := publishingFrom(d)
+	msg.Headers = headers
+	return ch.PublishWithContext(context.Background(), "", target, false, false, msg)
+}
+
+// deliveryAttempt is which delivery of a message this is, counting from 1.
+func deliveryAttempt(headers amqp.Table) int {
+	if n, ok := headerInt(headers[HeaderAttempts]); ok && n > 0 {
+		return n
+	}
+	return 1
+}
+
+func publishingFrom(d amqp.Delivery) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         d.Headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		UserId:          d.UserId,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
@@ -0,0 +1,178 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 6, InitialDelay: time.Second, Multiplier: 2, MaxDelay: 5 * time.Second}
+	for attempt, want := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		5: 5 * time.Second,
+	} {
+		if got := policy.Delay(attempt); got != want {
+			t.Errorf("attempt %d: got %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+func TestSubscribeRejectsBadRetryPolicy(t *testing.T) {
+	_, conn, _ := perilBroker(t)
+	for name, policy := range map[string]RetryPolicy{
+		"no delay":       {MaxAttempts: 3},
+		"negative delay": {MaxAttempts: 3, InitialDelay: -time.Second},
+		"one attempt":    {MaxAttempts: 1, InitialDelay: time.Second},
+		"no attempts":    {InitialDelay: time.Second},
+	} {
+		sub, err := SubscribeJSONWithContext(
+			context.Background(),
+			conn,
+			routing.ExchangePerilTopic,
+			"bad_retry_test",
+			routing.ArmyMovesKey("retry").Pattern(),
+			Transient,
+			func(string) ActType { return Ack },
+			WithRetry(policy),
+		)
+		if err == nil {
+			sub.Stop(context.Background())
+			t.Errorf("%s: subscribed, want the policy rejected", name)
+		}
+	}
+}
+
+// subscribeRetries subscribes queue with policy and a handler that returns
+// the given acts in turn, reporting each message's envelope as it arrives.
+func subscribeRetries(t *testing.T, conn Transport, queue string, policy RetryPolicy, acts ...ActType) <-chan Envelope {
+	t.Helper()
+	envs := make(chan Envelope, len(acts))
+	sub, err := SubscribeEnvelope(
+		context.Background(),
+		conn,
+		routing.ExchangePerilTopic,
+		queue,
+		routing.ArmyMovesKey("retry").Pattern(),
+		Transient,
+		func(_ string, env Envelope) ActType {
+			envs <- env
+			act := acts[0]
+			acts = acts[1:]
+			return act
+		},
+		WithRetry(policy),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Stop(context.Background()) })
+	return envs
+}
+
+func nextEnvelope(t *testing.T, envs <-chan Envelope) Envelope {
+	t.Helper()
+	select {
+	case env := <-envs:
+		return env
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler never ran")
+		return Envelope{}
+	}
+}
+
+func TestRetryDeclaresQueuePerDelay(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	const queue = "retry_queues_test"
+	policy := RetryPolicy{MaxAttempts: 4, InitialDelay: 10 * time.Millisecond, Multiplier: 2, MaxDelay: 20 * time.Millisecond}
+	subscribeRetries(t, conn, queue, policy)
+
+	// Attempts 2 and 3 both wait the capped 20ms, so share a queue.
+	for _, name := range []string{
+		RetryQueueName(queue, 10*time.Millisecond),
+		RetryQueueName(queue, 20*time.Millisecond),
+		ParkingQueueName(queue),
+	} {
+		if _, err := ch.QueueDeclarePassive(name, false, false, true, false, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := ch.QueueDeclarePassive(RetryQueueName(queue, 40*time.Millisecond), false, false, true, false, nil); err == nil {
+		t.Error("declared a queue for 40ms, past the policy's max delay")
+	}
+}
+
+func TestRetryParksAfterMaxAttempts(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	const queue = "retry_park_test"
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: 20 * time.Millisecond, Multiplier: 2}
+	envs := subscribeRetries(t, conn, queue, policy, RetryLater, RetryLater, RetryLater)
+	parked := retriesParked.With(queue)
+	parkedBefore := parked.Value()
+
+	if err := PublishJSON(ch, routing.ExchangePerilTopic, routing.ArmyMovesKey("retry"), "again"); err != nil {
+		t.Fatal(err)
+	}
+	var last time.Time
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		env := nextEnvelope(t, envs)
+		if env.Attempt != attempt {
+			t.Errorf("delivery %d: attempt %d", attempt, env.Attempt)
+		}
+		if env.RoutingKey != routing.ArmyMovesKey("retry").String() {
+			t.Errorf("delivery %d: routing key %q, want the original", attempt, env.RoutingKey)
+		}
+		// Each retry waits out its own queue's TTL.
+		if attempt > 1 {
+			if wait, delay := time.Since(last), policy.Delay(attempt-1); wait < delay {
+				t.Errorf("attempt %d came after %v, want at least %v", attempt, wait, delay)
+			}
+		}
+		last = time.Now()
+	}
+
+	var got string
+	env := waitGet(t, ch, ParkingQueueName(queue), &got)
+	if got != "again" {
+		t.Errorf("parked %q, want the message", got)
+	}
+	if n, _ := headerInt(env.Headers[HeaderAttempts]); n != policy.MaxAttempts {
+		t.Errorf("parked with attempts header %v, want %d", env.Headers[HeaderAttempts], policy.MaxAttempts)
+	}
+	if n := parked.Value() - parkedBefore; n != 1 {
+		t.Errorf("%v messages counted parked, want 1", n)
+	}
+	select {
+	case env := <-envs:
+		t.Errorf("delivered again after parking: attempt %d", env.Attempt)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRetryLaterThenAck(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	const queue = "retry_ack_test"
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: 10 * time.Millisecond}
+	envs := subscribeRetries(t, conn, queue, policy, RetryLater, Ack)
+
+	if err := PublishJSON(ch, routing.ExchangePerilTopic, routing.ArmyMovesKey("retry"), "once more"); err != nil {
+		t.Fatal(err)
+	}
+	nextEnvelope(t, envs)
+	if env := nextEnvelope(t, envs); env.Attempt != 2 {
+		t.Errorf("retried as attempt %d, want 2", env.Attempt)
+	}
+	time.Sleep(50 * time.Millisecond)
+	q, err := ch.QueueDeclarePassive(ParkingQueueName(queue), false, false, true, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Messages != 0 {
+		t.Errorf("%d messages parked, want none", q.Messages)
+	}
+}
@@ -0,0 +1,258 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// HeaderRPCError marks a reply as a refusal rather than an answer.
+const HeaderRPCError = "x-peril-rpc-error"
+
+// DefaultRequestTimeout applies to requests whose context has no deadline.
+const DefaultRequestTimeout = 5 * time.Second
+
+var (
+	ErrRequesterClosed = errors.New("requester closed")
+	ErrRequestRejected = errors.New("request rejected")
+)
+
+// Requester sends requests and routes the replies back to their callers. It
+// owns an exclusive reply queue and is safe for concurrent use.
+type Requester struct {
+	ch      Channel
+	codec   Codec
+	queue   string
+	tag     string
+	done    chan struct{}
+	mu      sync.Mutex
+	pending map[string]chan amqp.Delivery
+	closed  bool
+}
+
+// NewRequester opens a channel on conn and a reply queue for it. Requests
+// are encoded with codec; replies are decoded by their content type.
+func NewRequester(conn Transport, codec Codec) (*Requester, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	// The queue is named here rather than by the broker so a managed channel
+	// declares the same one again after a reconnect.
+	queue := "peril.reply." + newMessageID()
+	if _, err := ch.QueueDeclare(queue, false, true, true, false, nil); err != nil {
+		ch.Close()
+		return nil, err
+	}
+	tag := newConsumerTag()
+	deliveries, err := ch.Consume(queue, tag, true, true, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+	r := &Requester{
+		ch:      ch,
+		codec:   codec,
+		queue:   queue,
+		tag:     tag,
+		done:    make(chan struct{}),
+		pending: map[string]chan amqp.Delivery{},
+	}
+	go r.dispatch(deliveries)
+	return r, nil
+}
+
+func (r *Requester) dispatch(deliveries <-chan amqp.Delivery) {
+	defer close(r.done)
+	for d := range deliveries {
+		r.mu.Lock()
+		waiter, ok := r.pending[d.CorrelationId]
+		delete(r.pending, d.CorrelationId)
+		r.mu.Unlock()
+		if !ok {
+			log.Printf("Dropping reply to unknown request %s \n", d.CorrelationId)
+			continue
+		}
+		waiter <- d
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for id, waiter := range r.pending {
+		close(waiter)
+		delete(r.pending, id)
+	}
+}
+
+// Close stops taking replies. Requests still waiting fail with
+// ErrRequesterClosed.
+func (r *Requester) Close() error {
+	err := r.ch.Cancel(r.tag, false)
+	if closeErr := r.ch.Close(); closeErr != nil && !errors.Is(closeErr, amqp.ErrClosed) {
+		err = errors.Join(err, closeErr)
+	}
+	<-r.done
+	return err
+}
+
+// Request publishes req and waits for the reply, until ctx is done or, if
+// ctx has no deadline, for DefaultRequestTimeout. The request's message ID
+// doubles as its correlation ID, which the reply carries back.
+func Request[Req, Resp any](ctx context.Context, r *Requester, exchange string, key routing.Key, req Req) (Resp, error) {
+	var resp Resp
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+	ctx, span := tracing.Start(ctx, "request "+exchange, tracing.KindProducer,
+		"messaging.destination", exchange,
+		"messaging.routing_key", key.String(),
+	)
+	defer span.End()
+
+	body, err := r.codec.Marshal(req)
+	if err != nil {
+		span.SetError(err)
+		return resp, err
+	}
+	id := newMessageID()
+	waiter := make(chan amqp.Delivery, 1)
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return resp, ErrRequesterClosed
+	}
+	r.pending[id] = waiter
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, id)
+		r.mu.Unlock()
+	}()
+
+	msg := stamp(ctx, amqp.Publishing{
+		ContentType:   r.codec.ContentType(),
+		Body:          body,
+		MessageId:     id,
+		CorrelationId: id,
+		ReplyTo:       r.queue,
+	})
+	if err := r.ch.PublishWithContext(ctx, exchange, key.String(), false, false, msg); err != nil {
+		span.SetError(err)
+		return resp, err
+	}
+
+	select {
+	case d, ok := <-waiter:
+		if !ok {
+			return resp, ErrRequesterClosed
+		}
+		if reason, ok := d.Headers[HeaderRPCError].(string); ok {
+			err := fmt.Errorf("%w: %s", ErrRequestRejected, reason)
+			span.SetError(err)
+			return resp, err
+		}
+		resp, err := decoderFor[Resp](JSON)(d.ContentType, d.Body)
+		span.SetError(err)
+		return resp, err
+	case <-ctx.Done():
+		err := fmt.Errorf("request to %s %s: %w", exchange, key, ctx.Err())
+		span.SetError(err)
+		return resp, err
+	}
+}
+
+// Serve answers requests sent with Request. It subscribes like Subscribe and
+// settles each request by the ActType the handler returns. On Ack the
+// response goes back to the requester in the request's format; on
+// NackDiscard the requester gets ErrRequestRejected, as it does for a
+// request that can't be decoded, which then goes to the poison policy.
+// Requeued and retried requests are answered when they are handled again.
+func Serve[Req, Resp any](
+	ctx context.Context,
+	conn Transport,
+	exchange,
+	queueName string,
+	key routing.Pattern,
+	queueType SimpleQueueType,
+	handler func(Req, Envelope) (Resp, ActType),
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	replies, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	sub, err := subscribe(
+		ctx,
+		conn,
+		exchange,
+		queueName,
+		key,
+		queueType,
+		func(req Req, env Envelope) ActType {
+			resp, act := handler(req, env)
+			if env.ReplyTo == "" {
+				return act
+			}
+			switch act {
+			case Ack:
+				if err := reply(replies, env, resp); err != nil {
+					log.Printf("Could not reply to %s -> %v \n", env.MessageID, err)
+					return NackRequeue
+				}
+			case NackDiscard:
+				if err := refuse(replies, env, "handler discarded the request"); err != nil {
+					log.Printf("Could not reply to %s -> %v \n", env.MessageID, err)
+				}
+			}
+			return act
+		},
+		decoderFor[Req](JSON),
+		append(opts, func(o *subscribeOptions) {
+			o.undecodable = func(env Envelope, err error) {
+				if env.ReplyTo == "" {
+					return
+				}
+				if err := refuse(replies, env, fmt.Sprintf("could not decode request: %v", err)); err != nil {
+					log.Printf("Could not reply to %s -> %v \n", env.MessageID, err)
+				}
+			}
+		}),
+	)
+	if err != nil {
+		replies.Close()
+		return nil, err
+	}
+	go func() {
+		<-sub.Done()
+		replies.Close()
+	}()
+	return sub, nil
+}
+
+// reply sends resp to the requester of env, encoded like the request was.
+func reply[Resp any](ch Publisher, env Envelope, resp Resp) error {
+	codec := JSON
+	if env.ContentType != "" {
+		if c, err := CodecFor(env.ContentType); err == nil {
+			codec = c
+		}
+	}
+	ctx := ContextWithCorrelationID(env.Context(), env.CorrelationID)
+	return Publish(ctx, ch, codec, "", routing.QueueKey(env.ReplyTo), resp)
+}
+
+func refuse(ch Publisher, env Envelope, reason string) error {
+	ctx := ContextWithCorrelationID(env.Context(), env.CorrelationID)
+	return ch.PublishWithContext(ctx, "", env.ReplyTo, false, false, stamp(ctx, amqp.Publishing{
+		Headers: amqp.Table{HeaderRPCError: reason},
+	}))
+}
@@ -0,0 +1,93 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type sumRequest struct {
+	A, B int
+}
+
+// serveSums answers sumRequests on the rpc_test queue, refusing negative
+// numbers.
+func serveSums(t *testing.T, conn Transport) {
+	t.Helper()
+	sub, err := Serve(
+		context.Background(),
+		conn,
+		routing.ExchangePerilTopic,
+		"rpc_test",
+		routing.ArmyMovesKey("rpc").Pattern(),
+		Transient,
+		func(req sumRequest, _ Envelope) (int, ActType) {
+			if req.A < 0 || req.B < 0 {
+				return 0, NackDiscard
+			}
+			return req.A + req.B, Ack
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sub.Stop(context.Background()) })
+}
+
+func newRequester(t *testing.T, conn Transport) *Requester {
+	t.Helper()
+	r, err := NewRequester(conn, JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestRequestServeRoundTrip(t *testing.T) {
+	_, conn, _ := perilBroker(t)
+	serveSums(t, conn)
+	r := newRequester(t, conn)
+
+	sum, err := Request[sumRequest, int](context.Background(), r, routing.ExchangePerilTopic, routing.ArmyMovesKey("rpc"), sumRequest{A: 2, B: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 5 {
+		t.Errorf("got %d, want 5", sum)
+	}
+	_, err = Request[sumRequest, int](context.Background(), r, routing.ExchangePerilTopic, routing.ArmyMovesKey("rpc"), sumRequest{A: -1})
+	if !errors.Is(err, ErrRequestRejected) {
+		t.Errorf("got %v, want the discarded request rejected", err)
+	}
+}
+
+func TestServeRefusesUndecodableRequest(t *testing.T) {
+	_, conn, _ := perilBroker(t)
+	serveSums(t, conn)
+	r := newRequester(t, conn)
+
+	// Replies find their request by correlation ID, so getting the refusal
+	// at all means it carried the right one.
+	_, err := Request[string, int](context.Background(), r, routing.ExchangePerilTopic, routing.ArmyMovesKey("rpc"), "two and three")
+	if !errors.Is(err, ErrRequestRejected) || !strings.Contains(err.Error(), "could not decode") {
+		t.Errorf("got %v, want a rejection for the undecodable request", err)
+	}
+}
+
+func TestRequestTimesOut(t *testing.T) {
+	_, conn, _ := perilBroker(t)
+	r := newRequester(t, conn)
+
+	// Nobody serves the request, so it goes nowhere.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := Request[sumRequest, int](ctx, r, routing.ExchangePerilTopic, routing.ArmyMovesKey("rpc"), sumRequest{A: 2, B: 3})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the deadline exceeded", err)
+	}
+}
@@ -0,0 +1,101 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var consumerSeq atomic.Uint64
+
+func newConsumerTag() string {
+	return fmt.Sprintf("peril-%d", consumerSeq.Add(1))
+}
+
+// Subscription is a running consumer started by one of the Subscribe
+// functions.
+type Subscription struct {
+	ch     Channel
+	tag    string
+	queue  string
+	done   chan struct{}
+	cancel sync.Once
+	poison PoisonStats
+
+	// stopPoll tells the queue depth poller to stop, and polled is closed
+	// once it has.
+	stopPoll chan struct{}
+	polled   chan struct{}
+}
+
+func newSubscription(ch Channel, tag, queue string) *Subscription {
+	return &Subscription{
+		ch:    ch,
+		tag:   tag,
+		queue: queue,
+		done:  make(chan struct{}),
+
+		stopPoll: make(chan struct{}),
+		polled:   make(chan struct{}),
+	}
+}
+
+// Queue is the name of the queue being consumed.
+func (s *Subscription) Queue() string {
+	return s.queue
+}
+
+// PoisonStats counts the deliveries this subscription could not decode.
+func (s *Subscription) PoisonStats() *PoisonStats {
+	return &s.poison
+}
+
+// Done is closed once the consumer has stopped and its last handler returned.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Stop cancels the consumer so no new deliveries arrive, waits for in-flight
+// handlers to finish and ack or nack, then closes the channel. If ctx ends
+// first the channel is closed anyway, which hands any unacked deliveries
+// back to the broker. Stop is safe to call more than once.
+func (s *Subscription) Stop(ctx context.Context) error {
+	var err error
+	s.cancel.Do(func() {
+		// The poller is done with the queue before draining starts.
+		close(s.stopPoll)
+		select {
+		case <-s.polled:
+		case <-ctx.Done():
+		}
+		err = s.ch.Cancel(s.tag, false)
+	})
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		err = errors.Join(err, fmt.Errorf("draining %s: %w", s.queue, ctx.Err()))
+	}
+	if closeErr := s.ch.Close(); closeErr != nil && !errors.Is(closeErr, amqp.ErrClosed) {
+		err = errors.Join(err, closeErr)
+	}
+	return err
+}
+
+// StopAll stops every subscription, draining them in parallel.
+func StopAll(ctx context.Context, subs ...*Subscription) error {
+	errs := make([]error, len(subs))
+	var wg sync.WaitGroup
+	for i, sub := range subs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = sub.Stop(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
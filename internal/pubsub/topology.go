@@ -0,0 +1,30 @@
+package pubsub
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ApplyTopology declares every exchange, queue and binding in t. Declaring
+// something that already exists with the same settings is a no-op, so it is
+// safe to run at every startup.
+func ApplyTopology(ch Channel, t routing.Topology) error {
+	for _, ex := range t.Exchanges {
+		if err := ch.ExchangeDeclare(ex.Name, ex.Kind, ex.Durable, ex.AutoDelete, ex.Internal, false, amqp.Table(ex.Args)); err != nil {
+			return fmt.Errorf("declaring exchange %s: %w", ex.Name, err)
+		}
+	}
+	for _, q := range t.Queues {
+		if _, err := ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, amqp.Table(q.Args)); err != nil {
+			return fmt.Errorf("declaring queue %s: %w", q.Name, err)
+		}
+	}
+	for _, b := range t.Bindings {
+		if err := ch.QueueBind(b.Queue, string(b.Key), b.Exchange, false, amqp.Table(b.Args)); err != nil {
+			return fmt.Errorf("binding %s to %s with %q: %w", b.Queue, b.Exchange, b.Key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package pubsub
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Publisher is what PublishJSON and PublishGob publish through: any Channel,
+// or a ConfirmedPublisher when the caller needs to know the broker got it.
+type Publisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// Channel is the subset of *amqp.Channel this package relies on. A raw
+// *amqp.Channel satisfies it as is, and so does a channel from a MemoryBroker.
+type Channel interface {
+	Publisher
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+	Confirm(noWait bool) error
+	GetNextPublishSeqNo() uint64
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// Transport is a connection to a broker that hands out channels.
+type Transport interface {
+	Channel() (Channel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+type amqpTransport struct {
+	conn *amqp.Connection
+}
+
+// NewAMQPTransport wraps an open RabbitMQ connection as a Transport.
+func NewAMQPTransport(conn *amqp.Connection) Transport {
+	return &amqpTransport{conn: conn}
+}
+
+// Dial connects to RabbitMQ at url.
+func Dial(url string) (Transport, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return NewAMQPTransport(conn), nil
+}
+
+func (t *amqpTransport) Channel() (Channel, error) {
+	ch, err := t.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+func (t *amqpTransport) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	return t.conn.NotifyClose(receiver)
+}
+
+func (t *amqpTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,67 @@
+package pubsub
+
+import (
+	"hash/fnv"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// fanOut runs handle on every delivery using the given number of workers,
+// and returns once deliveries is closed and the last handle has returned.
+// When ordered is set, deliveries that share a routing key always go to the
+// same worker. Each worker then has its own lane, buffered to size so a busy
+// lane doesn't hold up the others.
+func fanOut(deliveries <-chan amqp.Delivery, workers, size int, ordered bool, handle func(amqp.Delivery)) {
+	if workers <= 1 {
+		for d := range deliveries {
+			handle(d)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	work := func(lane <-chan amqp.Delivery) {
+		defer wg.Done()
+		for d := range lane {
+			handle(d)
+		}
+	}
+	if !ordered {
+		wg.Add(workers)
+		for range workers {
+			go work(deliveries)
+		}
+		wg.Wait()
+		return
+	}
+
+	lanes := make([]chan amqp.Delivery, workers)
+	wg.Add(workers)
+	for i := range lanes {
+		lanes[i] = make(chan amqp.Delivery, size)
+		go work(lanes[i])
+	}
+	for d := range deliveries {
+		lanes[laneFor(orderingKey(d), workers)] <- d
+	}
+	for _, lane := range lanes {
+		close(lane)
+	}
+	wg.Wait()
+}
+
+// orderingKey is the routing key the message was first published with, so a
+// retried message stays in its original lane.
+func orderingKey(d amqp.Delivery) string {
+	if key, ok := d.Headers[HeaderOriginalRoutingKey].(string); ok {
+		return key
+	}
+	return d.RoutingKey
+}
+
+func laneFor(key string, lanes int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(lanes))
+}
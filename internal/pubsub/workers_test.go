@@ -0,0 +1,100 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestOrderedKeysKeepOrderAndRunInParallel(t *testing.T) {
+	_, conn, ch := perilBroker(t)
+	const workers, n = 4, 10
+	slowKey := routing.ArmyMovesKey("slow")
+	var fastKey routing.Key
+	for i := 0; ; i++ {
+		fastKey = routing.ArmyMovesKey(fmt.Sprint("fast", i))
+		if laneFor(fastKey.String(), workers) != laneFor(slowKey.String(), workers) {
+			break
+		}
+	}
+	slow, fast := slowKey.String(), fastKey.String()
+
+	var mu sync.Mutex
+	handled := map[string][]int{}
+	release := make(chan struct{})
+	fastDone := make(chan struct{})
+	sub, err := SubscribeEnvelope(
+		context.Background(),
+		conn,
+		routing.ExchangePerilTopic,
+		"lanes_test",
+		routing.ArmyMovesPattern,
+		Transient,
+		func(i int, env Envelope) ActType {
+			// The slow key's first message holds its lane until every fast
+			// message is through.
+			if env.RoutingKey == slow && i == 0 {
+				<-release
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			handled[env.RoutingKey] = append(handled[env.RoutingKey], i)
+			if env.RoutingKey == fast && len(handled[fast]) == n {
+				close(fastDone)
+			}
+			return Ack
+		},
+		WithWorkers(workers),
+		WithOrderedKeys(),
+		WithPrefetch(2*n),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Stop(context.Background())
+
+	for i := 0; i < n; i++ {
+		for _, key := range []routing.Key{slowKey, fastKey} {
+			if err := PublishJSON(ch, routing.ExchangePerilTopic, key, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	select {
+	case <-fastDone:
+	case <-time.After(2 * time.Second):
+		close(release)
+		t.Fatal("the fast key waited on the slow one")
+	}
+	close(release)
+
+	want := make([]int, n)
+	for i := range want {
+		want[i] = i
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		got := append([]int(nil), handled[slow]...)
+		fastGot := append([]int(nil), handled[fast]...)
+		mu.Unlock()
+		if len(got) == n {
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s handled in order %v, want %v", slow, got, want)
+			}
+			if !reflect.DeepEqual(fastGot, want) {
+				t.Errorf("%s handled in order %v, want %v", fast, fastGot, want)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("handled %d of %d slow messages", len(got), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,190 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Key is a routing key a message is published with. Only the constructors
+// below can build one, so usernames in it are always escaped.
+type Key struct {
+	s string
+}
+
+func (k Key) String() string {
+	return k.s
+}
+
+// Pattern is a binding key, which may contain * and # wildcards.
+type Pattern string
+
+// MaxUsernameLength keeps escaped usernames well inside AMQP's 255 byte
+// limit on routing keys.
+const MaxUsernameLength = 32
+
+// ValidateUsername reports why username can't be used in Peril, if it can't.
+func ValidateUsername(username string) error {
+	switch {
+	case username == "":
+		return errors.New("username is empty")
+	case !utf8.ValidString(username):
+		return errors.New("username is not valid UTF-8")
+	case utf8.RuneCountInString(username) > MaxUsernameLength:
+		return fmt.Errorf("username is longer than %d characters", MaxUsernameLength)
+	}
+	return nil
+}
+
+// EscapeUsername makes username safe to use as one word of a routing key or
+// queue name: anything but letters, digits, - and _ is percent-encoded, so
+// dots and wildcards can't change how a key routes.
+func EscapeUsername(username string) string {
+	var b strings.Builder
+	for i := 0; i < len(username); i++ {
+		c := username[i]
+		if isKeySafe(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func isKeySafe(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_'
+}
+
+// UnescapeUsername reverses EscapeUsername.
+func UnescapeUsername(word string) (string, error) {
+	for i := 0; i < len(word); i++ {
+		if c := word[i]; c != '%' && !isKeySafe(c) {
+			return "", fmt.Errorf("invalid character %q in escaped username %q", c, word)
+		}
+	}
+	username, err := url.PathUnescape(word)
+	if err != nil {
+		return "", fmt.Errorf("invalid escaped username %q: %w", word, err)
+	}
+	return username, ValidateUsername(username)
+}
+
+// ArmyMovesKey is the key username's moves are published with.
+func ArmyMovesKey(username string) Key {
+	return Key{ArmyMovesPrefix + "." + EscapeUsername(username)}
+}
+
+// WarKey is the key recognitions of war addressed to username are published
+// with. Only username's war queue is bound to it.
+func WarKey(username string) Key {
+	return Key{WarRecognitionsPrefix + "." + EscapeUsername(username)}
+}
+
+// WarResultKey is the key results of wars fought against username are
+// published with.
+func WarResultKey(username string) Key {
+	return Key{WarResultsPrefix + "." + EscapeUsername(username)}
+}
+
+// GameLogKey is the key username's game logs are published with.
+func GameLogKey(username string) Key {
+	return Key{GameLogSlug + "." + EscapeUsername(username)}
+}
+
+// QueueKey is the key that takes a message straight to queue through the
+// default exchange, the way replies are sent.
+func QueueKey(queue string) Key {
+	return Key{queue}
+}
+
+// Pattern is a binding pattern that matches exactly k.
+func (k Key) Pattern() Pattern {
+	return Pattern(k.s)
+}
+
+var (
+	// PauseRoutingKey is the key pause and resume messages are published
+	// with.
+	PauseRoutingKey = Key{PauseKey}
+
+	// MapRoutingKey is the key the server broadcasts the map with.
+	MapRoutingKey = Key{MapKey}
+
+	// MapRequestKey is the key clients ask for the map with.
+	MapRequestKey = Key{MapKey + ".request"}
+)
+
+// ArmyMovesPattern matches every player's moves.
+const ArmyMovesPattern Pattern = ArmyMovesPrefix + ".*"
+
+// WarPattern matches recognitions of war addressed to any player.
+const WarPattern Pattern = WarRecognitionsPrefix + ".*"
+
+// GameLogPattern matches every player's game logs.
+const GameLogPattern Pattern = GameLogSlug + ".*"
+
+// PausePattern matches pause and resume messages.
+const PausePattern Pattern = PauseKey
+
+// MapPattern matches map broadcasts.
+const MapPattern Pattern = MapKey
+
+// ParseArmyMovesKey returns the player an army_moves key belongs to.
+func ParseArmyMovesKey(key string) (string, error) {
+	return parseUserKey(ArmyMovesPrefix, key)
+}
+
+// ParseWarKey returns the player a war key is addressed to.
+func ParseWarKey(key string) (string, error) {
+	return parseUserKey(WarRecognitionsPrefix, key)
+}
+
+// ParseWarResultKey returns the player a war_results key is addressed to.
+func ParseWarResultKey(key string) (string, error) {
+	return parseUserKey(WarResultsPrefix, key)
+}
+
+// ParseGameLogKey returns the player a game_logs key belongs to.
+func ParseGameLogKey(key string) (string, error) {
+	return parseUserKey(GameLogSlug, key)
+}
+
+func parseUserKey(prefix, key string) (string, error) {
+	word, ok := strings.CutPrefix(key, prefix+".")
+	if !ok {
+		return "", fmt.Errorf("routing key %q is not a %s key", key, prefix)
+	}
+	return UnescapeUsername(word)
+}
+
+// ArmyMovesQueue is the transient queue username receives moves on.
+func ArmyMovesQueue(username string) string {
+	return ArmyMovesPrefix + "." + EscapeUsername(username)
+}
+
+// WarQueue is the durable queue username receives recognitions of war on.
+// It outlives the client, so wars declared while a player is offline wait
+// for them.
+func WarQueue(username string) string {
+	return WarRecognitionsPrefix + "." + EscapeUsername(username)
+}
+
+// WarResultQueue is the durable queue username receives the results of wars
+// fought against them on, so they apply their losses even if they were
+// offline.
+func WarResultQueue(username string) string {
+	return WarResultsPrefix + "." + EscapeUsername(username)
+}
+
+// PauseQueue is the transient queue username receives pause messages on.
+func PauseQueue(username string) string {
+	return PauseKey + "." + EscapeUsername(username)
+}
+
+// MapQueue is the transient queue username receives map broadcasts on.
+func MapQueue(username string) string {
+	return MapKey + "." + EscapeUsername(username)
+}
@@ -0,0 +1,61 @@
+package routing
+
+import "testing"
+
+func TestEscapeUsername(t *testing.T) {
+	for _, tc := range []struct {
+		username, want string
+	}{
+		{"alice", "alice"},
+		{"Bob_the-2nd", "Bob_the-2nd"},
+		{"a.b", "a%2Eb"},
+		{"*", "%2A"},
+		{"#", "%23"},
+		{"100%", "100%25"},
+		{"al ice", "al%20ice"},
+		{"zoë", "zo%C3%AB"},
+	} {
+		if got := EscapeUsername(tc.username); got != tc.want {
+			t.Errorf("EscapeUsername(%q) = %q, want %q", tc.username, got, tc.want)
+		}
+	}
+}
+
+func TestParseAfterEscape(t *testing.T) {
+	parsers := []struct {
+		name  string
+		key   func(string) Key
+		parse func(string) (string, error)
+	}{
+		{"army moves", ArmyMovesKey, ParseArmyMovesKey},
+		{"war", WarKey, ParseWarKey},
+		{"war result", WarResultKey, ParseWarResultKey},
+		{"game log", GameLogKey, ParseGameLogKey},
+	}
+	for _, username := range []string{"alice", "a.b", "*", "#", "%", "a.*.#", "%2E", "zoë"} {
+		for _, p := range parsers {
+			got, err := p.parse(p.key(username).String())
+			if err != nil || got != username {
+				t.Errorf("%s key for %q parsed as %q, %v", p.name, username, got, err)
+			}
+		}
+	}
+}
+
+func TestParseRejectsBadKeys(t *testing.T) {
+	for _, key := range []string{
+		"",
+		"army_moves",
+		"war.alice",
+		"army_moves.a.b",
+		"army_moves.*",
+		"army_moves.#",
+		"army_moves.%",
+		"army_moves.%zz",
+		"army_moves.",
+	} {
+		if username, err := ParseArmyMovesKey(key); err == nil {
+			t.Errorf("parsed %q as %q, want an error", key, username)
+		}
+	}
+}
@@ -6,6 +6,11 @@ type PlayingState struct {
 	IsPaused bool
 }
 
+// MapRequest asks the server for the map being played.
+type MapRequest struct {
+	Username string
+}
+
 type GameLog struct {
 	CurrentTime time.Time
 	Message     string
@@ -5,12 +5,24 @@ const (
 
 	WarRecognitionsPrefix = "war"
 
+	WarResultsPrefix = "war_results"
+
 	PauseKey = "pause"
 
 	GameLogSlug = "game_logs"
+
+	MapKey = "map"
 )
 
 const (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
+	ExchangePerilDLX    = "peril_dlx"
+)
+
+const (
+	QueuePerilDLQ = "peril_dlq"
+	QueueGameLogs = GameLogSlug
+	// QueueMapRequests is where late joiners ask the server for the map.
+	QueueMapRequests = "map_requests"
 )
@@ -0,0 +1,68 @@
+package routing
+
+const (
+	KindDirect = "direct"
+	KindTopic  = "topic"
+	KindFanout = "fanout"
+)
+
+type Exchange struct {
+	Name       string
+	Kind       string
+	Durable    bool
+	AutoDelete bool
+	Internal   bool
+	Args       map[string]any
+}
+
+type Queue struct {
+	Name       string
+	Durable    bool
+	AutoDelete bool
+	Exclusive  bool
+	Args       map[string]any
+}
+
+type Binding struct {
+	Exchange string
+	Queue    string
+	Key      Pattern
+	Args     map[string]any
+}
+
+// Topology is a set of exchanges, queues and bindings to declare on a broker.
+type Topology struct {
+	Exchanges []Exchange
+	Queues    []Queue
+	Bindings  []Binding
+}
+
+// DeadLetterArgs are the arguments every Peril queue is declared with.
+func DeadLetterArgs() map[string]any {
+	return map[string]any{"x-dead-letter-exchange": ExchangePerilDLX}
+}
+
+// PerilTopology is everything Peril needs that outlives a single player:
+// the exchanges, the dead-letter queue and the shared durable queues.
+// Per-player queues such as war.<username>, and the retry and parking queues
+// subscriptions add for themselves, are declared by the programs that use
+// them.
+func PerilTopology() Topology {
+	return Topology{
+		Exchanges: []Exchange{
+			{Name: ExchangePerilDirect, Kind: KindDirect, Durable: true},
+			{Name: ExchangePerilTopic, Kind: KindTopic, Durable: true},
+			{Name: ExchangePerilDLX, Kind: KindFanout, Durable: true},
+		},
+		Queues: []Queue{
+			{Name: QueuePerilDLQ, Durable: true},
+			{Name: QueueGameLogs, Durable: true, Args: DeadLetterArgs()},
+			{Name: QueueMapRequests, Durable: true, Args: DeadLetterArgs()},
+		},
+		Bindings: []Binding{
+			{Exchange: ExchangePerilDLX, Queue: QueuePerilDLQ, Key: ""},
+			{Exchange: ExchangePerilTopic, Queue: QueueGameLogs, Key: GameLogPattern},
+			{Exchange: ExchangePerilDirect, Queue: QueueMapRequests, Key: MapRequestKey.Pattern()},
+		},
+	}
+}
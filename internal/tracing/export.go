@@ -0,0 +1,331 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Exporter sends finished spans somewhere.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// flushInterval is how often buffered spans are exported.
+const flushInterval = time.Second
+
+// maxBuffered spans trigger an export straight away.
+const maxBuffered = 256
+
+var pipeline struct {
+	mu       sync.Mutex
+	exporter Exporter
+	buffer   []SpanData
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// SetExporter starts sending finished spans to e in batches, shutting down
+// any exporter set before. Until it is called spans are still created and
+// propagated, just not exported.
+func SetExporter(e Exporter) {
+	Shutdown(context.Background())
+	pipeline.mu.Lock()
+	defer pipeline.mu.Unlock()
+	pipeline.exporter = e
+	pipeline.stop = make(chan struct{})
+	pipeline.done = make(chan struct{})
+	go flushLoop(pipeline.stop, pipeline.done)
+}
+
+func flushLoop(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := Flush(context.Background()); err != nil {
+				log.Printf("Could not export spans -> %v \n", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func record(span SpanData) {
+	pipeline.mu.Lock()
+	if pipeline.exporter == nil {
+		pipeline.mu.Unlock()
+		return
+	}
+	pipeline.buffer = append(pipeline.buffer, span)
+	full := len(pipeline.buffer) >= maxBuffered
+	pipeline.mu.Unlock()
+	if full {
+		go Flush(context.Background())
+	}
+}
+
+// Flush exports every span finished so far.
+func Flush(ctx context.Context) error {
+	pipeline.mu.Lock()
+	exporter, spans := pipeline.exporter, pipeline.buffer
+	pipeline.buffer = nil
+	pipeline.mu.Unlock()
+	if exporter == nil || len(spans) == 0 {
+		return nil
+	}
+	return exporter.Export(ctx, spans)
+}
+
+// Shutdown flushes what is buffered and stops exporting, closing the
+// exporter if it is an io.Closer.
+func Shutdown(ctx context.Context) error {
+	pipeline.mu.Lock()
+	stop, done := pipeline.stop, pipeline.done
+	pipeline.stop, pipeline.done = nil, nil
+	pipeline.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	err := Flush(ctx)
+	pipeline.mu.Lock()
+	exporter := pipeline.exporter
+	pipeline.exporter = nil
+	pipeline.mu.Unlock()
+	if closer, ok := exporter.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+	return err
+}
+
+// Setup exports spans to a JSON file at path, to an OTLP collector at
+// endpoint, or to both. Empty arguments are skipped, and with neither it does
+// nothing.
+func Setup(path, endpoint, service string) error {
+	var exporters multiExporter
+	if path != "" {
+		e, err := NewFileExporter(path)
+		if err != nil {
+			return err
+		}
+		exporters = append(exporters, e)
+	}
+	if endpoint != "" {
+		exporters = append(exporters, NewOTLPExporter(endpoint, service))
+	}
+	if len(exporters) > 0 {
+		SetExporter(exporters)
+	}
+	return nil
+}
+
+type multiExporter []Exporter
+
+func (m multiExporter) Export(ctx context.Context, spans []SpanData) error {
+	var errs []error
+	for _, e := range m {
+		errs = append(errs, e.Export(ctx, spans))
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every exporter that is an io.Closer.
+func (m multiExporter) Close() error {
+	var errs []error
+	for _, e := range m {
+		if closer, ok := e.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FileExporter appends spans to a file as JSON, one span per line.
+type FileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+type fileSpan struct {
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	Name         string            `json:"name"`
+	Kind         string            `json:"kind"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	DurationMS   float64           `json:"duration_ms"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// NewFileExporter opens path for appending, creating it if needed.
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open trace file: %v", err)
+	}
+	return &FileExporter{file: f}, nil
+}
+
+func (e *FileExporter) Export(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	encoder := json.NewEncoder(e.file)
+	for _, span := range spans {
+		out := fileSpan{
+			TraceID:    hex.EncodeToString(span.Context.TraceID[:]),
+			SpanID:     hex.EncodeToString(span.Context.SpanID[:]),
+			Name:       span.Name,
+			Kind:       span.Kind.String(),
+			Start:      span.Start,
+			End:        span.End,
+			DurationMS: float64(span.End.Sub(span.Start)) / float64(time.Millisecond),
+			Attributes: span.Attributes,
+		}
+		if span.Parent != [8]byte{} {
+			out.ParentSpanID = hex.EncodeToString(span.Parent[:])
+		}
+		if span.Err != nil {
+			out.Error = span.Err.Error()
+		}
+		if err := encoder.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the file.
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
+
+// OTLPExporter posts spans to an OpenTelemetry collector using OTLP over
+// HTTP with JSON encoding.
+type OTLPExporter struct {
+	url     string
+	service string
+	client  *http.Client
+}
+
+// NewOTLPExporter sends spans to the collector at endpoint, such as
+// http://localhost:4318, labelled with service as their service.name.
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{
+		url:     endpoint + "/v1/traces",
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+// OTLP span kinds and status codes.
+const (
+	otlpKindInternal = 1
+	otlpKindProducer = 4
+	otlpKindConsumer = 5
+	otlpStatusOK     = 1
+	otlpStatusError  = 2
+)
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(span.Context.SpanID[:]),
+			Name:              span.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		switch span.Kind {
+		case KindProducer:
+			s.Kind = otlpKindProducer
+		case KindConsumer:
+			s.Kind = otlpKindConsumer
+		}
+		if span.Parent != [8]byte{} {
+			s.ParentSpanID = hex.EncodeToString(span.Parent[:])
+		}
+		for k, v := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpAttribute{Key: k, Value: otlpValue{StringValue: v}})
+		}
+		if span.Err != nil {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: span.Err.Error()}
+		}
+		out = append(out, s)
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: e.service}}},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "peril"},
+				"spans": out,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	e, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetExporter(multiExporter{e})
+
+	ctx, parent := Start(context.Background(), "publish", KindProducer, "routing_key", "army_moves.alice")
+	_, child := Start(ctx, "handle", KindConsumer)
+	child.SetError(errors.New("boom"))
+	child.End()
+	parent.End()
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var spans []fileSpan
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		var s fileSpan
+		if err := json.Unmarshal(lines.Bytes(), &s); err != nil {
+			t.Fatalf("line %q: %v", lines.Text(), err)
+		}
+		spans = append(spans, s)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	handle, publish := spans[0], spans[1]
+	if publish.Name != "publish" || publish.Kind != "producer" || publish.ParentSpanID != "" ||
+		publish.Attributes["routing_key"] != "army_moves.alice" {
+		t.Errorf("publish span is %+v", publish)
+	}
+	if handle.Name != "handle" || handle.Kind != "consumer" || handle.Error != "boom" {
+		t.Errorf("handle span is %+v", handle)
+	}
+	if handle.TraceID != publish.TraceID || handle.ParentSpanID != publish.SpanID {
+		t.Errorf("handle span %+v is not under publish %+v", handle, publish)
+	}
+	if sc := parent.Context(); publish.TraceID != hex.EncodeToString(sc.TraceID[:]) || publish.DurationMS < 0 {
+		t.Errorf("publish span is %+v", publish)
+	}
+
+	// Shutdown closed the file along with the exporter holding it.
+	if err := e.Export(context.Background(), []SpanData{{Name: "late"}}); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("exporting after Shutdown: got %v, want the file closed", err)
+	}
+}
+
+func TestUnsampledSpansAreNotExported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	e, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetExporter(e)
+	unsampled := SpanContext{TraceID: [16]byte{15: 1}, SpanID: [8]byte{7: 1}}
+	_, span := Start(ContextWithRemoteParent(context.Background(), unsampled), "handle", KindConsumer)
+	span.End()
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Errorf("got %v, %v, want an empty trace file", info, err)
+	}
+}
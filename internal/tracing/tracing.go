@@ -0,0 +1,226 @@
+// Package tracing propagates W3C trace context through messages and records
+// spans for an Exporter.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether sc has both a trace and a span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%x-%x-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// ParseTraceparent reads a W3C traceparent header value.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(sc.TraceID) {
+		return sc, fmt.Errorf("invalid trace ID in %q", s)
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(sc.SpanID) {
+		return sc, fmt.Errorf("invalid span ID in %q", s)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, fmt.Errorf("invalid trace flags in %q", s)
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, errors.New("traceparent has an all-zero ID")
+	}
+	return sc, nil
+}
+
+// Kind says what part a span plays in a message's journey.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindProducer
+	KindConsumer
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindProducer:
+		return "producer"
+	case KindConsumer:
+		return "consumer"
+	default:
+		return "internal"
+	}
+}
+
+// Span is one timed operation in a trace.
+type Span struct {
+	mu         sync.Mutex
+	name       string
+	kind       Kind
+	context    SpanContext
+	parent     [8]byte
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        error
+	ended      bool
+}
+
+// SpanData is a finished span as exporters see it.
+type SpanData struct {
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     [8]byte
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Err        error
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// Start begins a span named name as a child of whatever span ctx carries,
+// or of a remote parent set with ContextWithRemoteParent. Without either it
+// starts a new trace. The returned context carries the new span.
+func Start(ctx context.Context, name string, kind Kind, attributes ...string) (context.Context, *Span) {
+	span := &Span{
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: map[string]string{},
+	}
+	parent, ok := parentFrom(ctx)
+	if ok {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+		span.parent = parent.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+		span.context.Sampled = true
+	}
+	rand.Read(span.context.SpanID[:])
+	for i := 0; i+1 < len(attributes); i += 2 {
+		span.attributes[attributes[i]] = attributes[i+1]
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func parentFrom(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.context, true
+	}
+	if sc, ok := ctx.Value(remoteKey{}).(SpanContext); ok && sc.IsValid() {
+		return sc, true
+	}
+	return SpanContext{}, false
+}
+
+// SpanFromContext returns the span ctx carries, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent makes the next span started from ctx a child of
+// sc, typically read from an incoming message.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// Traceparent returns the traceparent header for the span in ctx, or "" if
+// there is none.
+func Traceparent(ctx context.Context) string {
+	sc, ok := parentFrom(ctx)
+	if !ok {
+		return ""
+	}
+	return sc.Traceparent()
+}
+
+// Context is the span's identity, for propagating to other processes.
+func (s *Span) Context() SpanContext {
+	return s.context
+}
+
+// SetAttribute records a key/value pair on the span.
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed with err. A nil err is ignored.
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// End finishes the span and hands it to the exporter, if one is set. Only
+// the first call has any effect.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	attributes := make(map[string]string, len(s.attributes))
+	for k, v := range s.attributes {
+		attributes[k] = v
+	}
+	data := SpanData{
+		Name:       s.name,
+		Kind:       s.kind,
+		Context:    s.context,
+		Parent:     s.parent,
+		Start:      s.start,
+		End:        s.end,
+		Attributes: attributes,
+		Err:        s.err,
+	}
+	s.mu.Unlock()
+	if s.context.Sampled {
+		record(data)
+	}
+}
@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	_, span := Start(context.Background(), "publish", KindProducer)
+	for _, sc := range []SpanContext{span.Context(), {TraceID: [16]byte{15: 1}, SpanID: [8]byte{7: 1}}} {
+		got, err := ParseTraceparent(sc.Traceparent())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != sc {
+			t.Errorf("%s parsed as %+v, want %+v", sc.Traceparent(), got, sc)
+		}
+	}
+}
+
+func TestParseTraceparentRejects(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-zzf067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+	} {
+		if sc, err := ParseTraceparent(s); err == nil {
+			t.Errorf("parsed %q as %+v, want an error", s, sc)
+		}
+	}
+	// Later versions may append fields.
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Errorf("rejected a later version with more fields: %v", err)
+	}
+}
+
+func TestChildSpansShareTheTrace(t *testing.T) {
+	_, remote := Start(context.Background(), "publish", KindProducer)
+	ctx := ContextWithRemoteParent(context.Background(), remote.Context())
+	ctx, child := Start(ctx, "handle", KindConsumer)
+	if child.Context().TraceID != remote.Context().TraceID || child.parent != remote.Context().SpanID {
+		t.Errorf("child %+v is not under %+v", child.Context(), remote.Context())
+	}
+	if got := Traceparent(ctx); got != child.Context().Traceparent() {
+		t.Errorf("context carries %s, want the child's %s", got, child.Context().Traceparent())
+	}
+}